@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Access log formats.
+const (
+	LogFormatCommon   = "common"
+	LogFormatCombined = "combined"
+	LogFormatJSON     = "json"
+)
+
+// clfTimeLayout is the timestamp layout of the Common Log Format.
+const clfTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// accessEntry describes one request for the access log. Requests rejected
+// before they were parsed have an empty method.
+type accessEntry struct {
+	remoteAddr string
+	time       time.Time
+	method     string
+	target     string
+	proto      string
+	status     int
+	bytes      int64
+	duration   time.Duration
+	referer    string
+	userAgent  string
+	// reused is how many requests the connection served before this one.
+	reused int
+}
+
+// newAccessEntry describes a request answered with resp on conn, which had
+// served reused requests before it.
+func newAccessEntry(conn net.Conn, req *Request, resp *response, start time.Time, reused int) accessEntry {
+	referer, _ := req.Headers.Get(HeaderReferer)
+	userAgent, _ := req.Headers.Get(HeaderUserAgent)
+	return accessEntry{
+		remoteAddr: conn.RemoteAddr().String(),
+		time:       start,
+		method:     req.Method,
+		target:     req.Target,
+		proto:      req.Version,
+		status:     resp.status,
+		bytes:      sentBytes(resp),
+		duration:   time.Since(start),
+		referer:    referer,
+		userAgent:  userAgent,
+		reused:     reused,
+	}
+}
+
+// sentBytes returns how many body bytes resp sent, which is none for a
+// response to HEAD even though its body is counted.
+func sentBytes(resp *response) int64 {
+	if resp.noBody {
+		return 0
+	}
+	return resp.written
+}
+
+// accessLog writes one line per request in the configured format. When it
+// writes to a file, Reopen lets the file be rotated.
+type accessLog struct {
+	format string
+	path   string // empty for stderr
+	json   *slog.Logger
+
+	mu sync.Mutex
+	f  *os.File
+	w  io.Writer
+}
+
+// newAccessLog returns an access log writing format to the file at path, or
+// to stderr if path is empty.
+func newAccessLog(format, path string) (*accessLog, error) {
+	switch format {
+	case LogFormatCommon, LogFormatCombined, LogFormatJSON:
+	default:
+		return nil, fmt.Errorf("unknown access log format %q", format)
+	}
+
+	l := &accessLog{format: format, path: path, w: os.Stderr}
+	if err := l.Reopen(); err != nil {
+		return nil, err
+	}
+	l.json = slog.New(slog.NewJSONHandler(l, nil))
+	return l, nil
+}
+
+// Reopen closes and reopens the log file, so that a file moved away by log
+// rotation is replaced by a new one.
+func (l *accessLog) Reopen() error {
+	if l.path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open access log: %w", err)
+	}
+
+	l.mu.Lock()
+	old := l.f
+	l.f, l.w = f, f
+	l.mu.Unlock()
+
+	if old != nil {
+		return old.Close()
+	}
+	return nil
+}
+
+// Close closes the log file.
+func (l *accessLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	l.w = io.Discard
+	return l.f.Close()
+}
+
+// Write writes one formatted entry to the current file.
+func (l *accessLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+func (l *accessLog) log(e accessEntry) {
+	if l.format == LogFormatJSON {
+		l.json.LogAttrs(context.Background(), slog.LevelInfo, "request",
+			slog.String("remote_addr", e.remoteAddr),
+			slog.String("method", e.method),
+			slog.String("target", e.target),
+			slog.String("proto", e.proto),
+			slog.Int("status", e.status),
+			slog.Int64("bytes", e.bytes),
+			slog.Float64("duration_ms", float64(e.duration.Microseconds())/1000),
+			slog.String("referer", e.referer),
+			slog.String("user_agent", e.userAgent),
+			slog.Int("reused", e.reused),
+		)
+		return
+	}
+
+	host := e.remoteAddr
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	requestLine := "-"
+	if e.method != "" {
+		requestLine = e.method + " " + e.target + " " + e.proto
+	}
+
+	// Quoted fields come from the client and are escaped so that they
+	// cannot forge log lines.
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s - - [%s] %s %d %s", orDash(host), e.time.Format(clfTimeLayout),
+		strconv.QuoteToASCII(requestLine), e.status, clfBytes(e.bytes))
+	if l.format == LogFormatCombined {
+		// The duration in seconds and the connection's reuse count follow
+		// the standard fields, where log parsers ignore them.
+		fmt.Fprintf(&b, " %s %s %.3f %d", strconv.QuoteToASCII(orDash(e.referer)), strconv.QuoteToASCII(orDash(e.userAgent)),
+			e.duration.Seconds(), e.reused)
+	}
+	b.WriteByte('\n')
+	l.Write([]byte(b.String()))
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// clfBytes formats a response size, which the Common Log Format writes as
+// "-" when no body was sent.
+func clfBytes(n int64) string {
+	if n == 0 {
+		return "-"
+	}
+	return strconv.FormatInt(n, 10)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testAccessEntry() accessEntry {
+	return accessEntry{
+		remoteAddr: "192.0.2.1:54321",
+		time:       time.Date(2024, time.March, 5, 13, 55, 36, 0, time.FixedZone("", -7*3600)),
+		method:     "GET",
+		target:     "/files/a.txt",
+		proto:      "HTTP/1.1",
+		status:     200,
+		bytes:      2326,
+		duration:   1500 * time.Microsecond,
+		referer:    "http://example.com/",
+		userAgent:  `curl/8.0 "quoted"`,
+		reused:     2,
+	}
+}
+
+func TestAccessLog_Formats(t *testing.T) {
+	rejected := accessEntry{
+		remoteAddr: "[2001:db8::1]:80",
+		time:       testAccessEntry().time,
+		status:     400,
+	}
+
+	tests := []struct {
+		name   string
+		format string
+		entry  accessEntry
+		want   string
+	}{
+		{
+			name:   "Common",
+			format: LogFormatCommon,
+			entry:  testAccessEntry(),
+			want:   `192.0.2.1 - - [05/Mar/2024:13:55:36 -0700] "GET /files/a.txt HTTP/1.1" 200 2326` + "\n",
+		},
+		{
+			name:   "Combined",
+			format: LogFormatCombined,
+			entry:  testAccessEntry(),
+			want:   `192.0.2.1 - - [05/Mar/2024:13:55:36 -0700] "GET /files/a.txt HTTP/1.1" 200 2326 "http://example.com/" "curl/8.0 \"quoted\"" 0.002 2` + "\n",
+		},
+		{
+			name:   "Rejected request",
+			format: LogFormatCombined,
+			entry:  rejected,
+			want:   `2001:db8::1 - - [05/Mar/2024:13:55:36 -0700] "-" 400 - "-" "-" 0.000 0` + "\n",
+		},
+		{
+			name:   "Control characters are escaped",
+			format: LogFormatCommon,
+			entry:  accessEntry{remoteAddr: "192.0.2.1:1", time: testAccessEntry().time, method: "GET", target: "/a\nb", proto: "HTTP/1.1", status: 404},
+			want:   `192.0.2.1 - - [05/Mar/2024:13:55:36 -0700] "GET /a\nb HTTP/1.1" 404 -` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "access.log")
+			l, err := newAccessLog(tt.format, path)
+			if err != nil {
+				t.Fatalf("newAccessLog() error = %v", err)
+			}
+			defer l.Close()
+
+			l.log(tt.entry)
+			got, _ := os.ReadFile(path)
+			if string(got) != tt.want {
+				t.Errorf("log line = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAccessLog_JSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	l, err := newAccessLog(LogFormatJSON, path)
+	if err != nil {
+		t.Fatalf("newAccessLog() error = %v", err)
+	}
+	defer l.Close()
+	l.log(testAccessEntry())
+
+	content, _ := os.ReadFile(path)
+	var got map[string]any
+	if err := json.Unmarshal(content, &got); err != nil {
+		t.Fatalf("log line %q is not JSON: %v", content, err)
+	}
+	want := map[string]any{
+		"msg":         "request",
+		"remote_addr": "192.0.2.1:54321",
+		"method":      "GET",
+		"target":      "/files/a.txt",
+		"proto":       "HTTP/1.1",
+		"status":      float64(200),
+		"bytes":       float64(2326),
+		"duration_ms": 1.5,
+		"referer":     "http://example.com/",
+		"user_agent":  `curl/8.0 "quoted"`,
+		"reused":      float64(2),
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %v, want %v", k, got[k], v)
+		}
+	}
+}
+
+func TestAccessLog_UnknownFormat(t *testing.T) {
+	if _, err := newAccessLog("apache", ""); err == nil {
+		t.Error("newAccessLog() with unknown format error = nil")
+	}
+}
+
+func TestAccessLog_Reopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	l, err := newAccessLog(LogFormatCommon, path)
+	if err != nil {
+		t.Fatalf("newAccessLog() error = %v", err)
+	}
+	defer l.Close()
+
+	first := testAccessEntry()
+	l.log(first)
+
+	// Rotate the file away, as logrotate would, then reopen.
+	rotated := filepath.Join(dir, "access.log.1")
+	if err := os.Rename(path, rotated); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Reopen(); err != nil {
+		t.Fatalf("Reopen() error = %v", err)
+	}
+	second := testAccessEntry()
+	second.status = 404
+	l.log(second)
+
+	old, _ := os.ReadFile(rotated)
+	current, _ := os.ReadFile(path)
+	if !strings.Contains(string(old), `" 200 `) || strings.Count(string(old), "\n") != 1 {
+		t.Errorf("rotated log = %q, want only the first entry", old)
+	}
+	if !strings.Contains(string(current), `" 404 `) || strings.Count(string(current), "\n") != 1 {
+		t.Errorf("reopened log = %q, want only the second entry", current)
+	}
+}
+
+func TestHandleConn_AccessLog(t *testing.T) {
+	server := createTestServer(t)
+	server.Register("GET", "/echo/{text...}", server.echoGet)
+	path := filepath.Join(t.TempDir(), "access.log")
+	l, err := newAccessLog(LogFormatJSON, path)
+	if err != nil {
+		t.Fatalf("newAccessLog() error = %v", err)
+	}
+	defer l.Close()
+	server.accessLog = l
+
+	client, done := serveConn(t, server)
+	client.SetDeadline(time.Now().Add(2 * time.Second))
+	go client.Write([]byte("GET /echo/one HTTP/1.1\r\nUser-Agent: test\r\n\r\n" +
+		"HEAD /echo/two HTTP/1.1\r\n\r\n" +
+		"GET /echo/three HTTP/1.1\r\nConnection: close\r\n\r\n"))
+	br := bufio.NewReader(client)
+	for _, method := range []string{"GET", "HEAD", "GET"} {
+		resp, err := http.ReadResponse(br, &http.Request{Method: method})
+		if err != nil {
+			t.Fatalf("ReadResponse() error = %v", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+	}
+	waitClosed(t, done, time.Second)
+
+	content, _ := os.ReadFile(path)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("access log has %d lines, want 3:\n%s", len(lines), content)
+	}
+	for i, want := range []struct {
+		target    string
+		userAgent string
+		reused    float64
+		bytes     float64
+	}{
+		{"/echo/one", "test", 0, 3},
+		{"/echo/two", "", 1, 0},
+		{"/echo/three", "", 2, 5},
+	} {
+		var e map[string]any
+		if err := json.Unmarshal([]byte(lines[i]), &e); err != nil {
+			t.Fatalf("line %d is not JSON: %v", i+1, err)
+		}
+		if e["target"] != want.target || e["user_agent"] != want.userAgent || e["reused"] != want.reused ||
+			e["status"] != float64(200) || e["bytes"] != want.bytes {
+			t.Errorf("line %d = %s, want target %s, user agent %q, reused %v, bytes %v", i+1, lines[i], want.target, want.userAgent, want.reused, want.bytes)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// bodyReader streams a request body that is read on demand rather than
+// before the handler runs. The first Read calls onStart, which is where the
+// server sends 100 Continue.
+type bodyReader struct {
+	r   io.Reader // Content-Length limited reader or chunkedReader
+	req *Request
+
+	length int64 // declared Content-Length, -1 for chunked bodies
+	limit  int64 // maximum body size
+	read   int64
+
+	onStart func() error
+	started bool
+	done    bool
+	err     error
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if !b.started {
+		b.started = true
+		if b.onStart != nil {
+			if b.err = b.onStart(); b.err != nil {
+				return 0, b.err
+			}
+		}
+	}
+
+	n, err := b.r.Read(p)
+	b.read += int64(n)
+	if b.read > b.limit {
+		b.err = newStatusError(http.StatusRequestEntityTooLarge, "chunked body exceeds limit of %d", b.limit)
+		return 0, b.err
+	}
+	if err == io.EOF {
+		b.done = true
+		if cr, ok := b.r.(*chunkedReader); ok {
+			b.req.Trailers = cr.trailers
+		} else if b.read < b.length {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+	if err != nil {
+		b.err = err
+	}
+	return n, err
+}
+
+// BodyReader returns a reader for the request body. For a request sent with
+// Expect: 100-continue the body is read from the connection as the handler
+// consumes it, and the client is only asked to send it on the first Read;
+// Body is empty for such requests. For other requests it reads Body.
+func (r *Request) BodyReader() io.Reader {
+	if r.body != nil {
+		return r.body
+	}
+	return bytes.NewReader(r.Body)
+}
+
+// checkExpect validates the Expect header (RFC 9110 section 10.1.1). The
+// only expectation is 100-continue; anything else is answered with 417.
+// HTTP/1.0 clients cannot handle interim responses, so their expectations
+// are ignored.
+func (r *Request) checkExpect() error {
+	v, ok := r.Headers.joined(HeaderExpect)
+	if !ok || r.Version == "HTTP/1.0" {
+		return nil
+	}
+	if !strings.EqualFold(strings.TrimSpace(v), ExpectContinue) {
+		return newStatusError(http.StatusExpectationFailed, "unsupported expectation %q", v)
+	}
+	r.expectContinue = true
+	return nil
+}
+
+// writeContinue sends the interim response that tells the client to go
+// ahead with the body.
+func writeContinue(bw *bufio.Writer) error {
+	if _, err := io.WriteString(bw, "HTTP/1.1 100 Continue\r\n\r\n"); err != nil {
+		return fmt.Errorf("failed to write 100 Continue: %w", err)
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write 100 Continue: %w", err)
+	}
+	return nil
+}
+
+// CheckContinue sets a hook that runs before the handler of a request sent
+// with Expect: 100-continue, once its route is known. If the hook returns an
+// error the handler is skipped and the request is rejected without the
+// client sending the body: a *statusError, as made by newStatusError, gives
+// the status and reason, any other error a 500.
+func (s *server) CheckContinue(check func(ctx context.Context, req *Request) error) {
+	s.continueCheck = check
+}
+
+// rejectContinue answers a request whose continue check failed.
+func rejectContinue(w ResponseWriter, err error) error {
+	code := http.StatusInternalServerError
+	reason := err.Error()
+	var se *statusError
+	if errors.As(err, &se) {
+		code, reason = se.code, se.reason
+	}
+	w.Header().Set(HeaderContentType, ContentTypeTextPlain)
+	return httpResponse(w, code, reason)
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startConn serves a single connection with server and returns the client
+// end, which is closed when the test ends.
+func startConn(t *testing.T, server *server) (net.Conn, *bufio.Reader) {
+	client, conn := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+	go server.handleConn(conn)
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	return client, bufio.NewReader(client)
+}
+
+func TestExpectContinue_Upload(t *testing.T) {
+	server := createTestServer(t)
+	server.Register("POST", "/files/{name...}", server.filesPost)
+	client, br := startConn(t, server)
+
+	go client.Write([]byte("POST /files/big.txt HTTP/1.1\r\nContent-Length: 11\r\nExpect: 100-continue\r\n\r\n"))
+
+	// Nothing of the body has been sent yet; the server must ask for it.
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("ReadResponse() error = %v", err)
+	}
+	if resp.StatusCode != http.StatusContinue {
+		t.Fatalf("interim status = %d, want 100", resp.StatusCode)
+	}
+
+	go client.Write([]byte("hello world"))
+	resp, err = http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("ReadResponse() error = %v", err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("final status = %d, want 201", resp.StatusCode)
+	}
+	if resp.Close {
+		t.Error("connection should stay open after the body was read")
+	}
+
+	content, err := os.ReadFile(filepath.Join(server.dir, "big.txt"))
+	if err != nil || string(content) != "hello world" {
+		t.Errorf("uploaded file = %q, %v, want hello world", content, err)
+	}
+}
+
+func TestExpectContinue_RejectedEarly(t *testing.T) {
+	tests := []struct {
+		name     string
+		check    func(ctx context.Context, req *Request) error
+		handler  handleFunc
+		wantCode int
+	}{
+		{
+			name: "Check rejects too large",
+			check: func(ctx context.Context, req *Request) error {
+				return newStatusError(http.StatusRequestEntityTooLarge, "quota exceeded")
+			},
+			wantCode: 413,
+		},
+		{
+			name: "Check rejects unauthorized",
+			check: func(ctx context.Context, req *Request) error {
+				if _, ok := req.Headers.Get("Authorization"); !ok {
+					return newStatusError(http.StatusUnauthorized, "missing credentials")
+				}
+				return nil
+			},
+			wantCode: 401,
+		},
+		{
+			name: "Handler rejects without reading",
+			handler: func(ctx context.Context, req *Request, w ResponseWriter) error {
+				return httpResponse(w, http.StatusConflict, "exists")
+			},
+			wantCode: 409,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := createTestServer(t)
+			handler := tt.handler
+			if handler == nil {
+				handler = server.filesPost
+			}
+			server.Register("POST", "/files/{name...}", handler)
+			if tt.check != nil {
+				server.CheckContinue(tt.check)
+			}
+			client, br := startConn(t, server)
+
+			go client.Write([]byte("POST /files/x HTTP/1.1\r\nContent-Length: 5\r\nExpect: 100-continue\r\n\r\n"))
+
+			resp, err := http.ReadResponse(br, nil)
+			if err != nil {
+				t.Fatalf("ReadResponse() error = %v", err)
+			}
+			if resp.StatusCode != tt.wantCode {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantCode)
+			}
+			if !resp.Close {
+				t.Error("connection should be closed when the body was never requested")
+			}
+			if _, err := os.Stat(filepath.Join(server.dir, "x")); err == nil {
+				t.Error("rejected upload created a file")
+			}
+		})
+	}
+}
+
+func TestExpectContinue_UnknownExpectation(t *testing.T) {
+	server := createTestServer(t)
+	server.Register("POST", "/files/{name...}", server.filesPost)
+	client, br := startConn(t, server)
+
+	go client.Write([]byte("POST /files/x HTTP/1.1\r\nContent-Length: 5\r\nExpect: 200-ok\r\n\r\n"))
+
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("ReadResponse() error = %v", err)
+	}
+	if resp.StatusCode != http.StatusExpectationFailed {
+		t.Errorf("status = %d, want 417", resp.StatusCode)
+	}
+}
+
+func TestExpectContinue_Parsing(t *testing.T) {
+	tests := []struct {
+		name         string
+		input        string
+		wantContinue bool
+		wantBody     string
+	}{
+		{
+			name:         "Content-Length body is deferred",
+			input:        "POST / HTTP/1.1\r\nExpect: 100-Continue\r\nContent-Length: 3\r\n\r\nabc",
+			wantContinue: true,
+			wantBody:     "abc",
+		},
+		{
+			name:         "Chunked body is deferred",
+			input:        "POST / HTTP/1.1\r\nExpect: 100-continue\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\nX-Sum: 1\r\n\r\n",
+			wantContinue: true,
+			wantBody:     "abc",
+		},
+		{
+			name:     "Empty body needs no continue",
+			input:    "POST / HTTP/1.1\r\nExpect: 100-continue\r\nContent-Length: 0\r\n\r\n",
+			wantBody: "",
+		},
+		{
+			name:     "HTTP/1.0 expectation is ignored",
+			input:    "POST / HTTP/1.0\r\nExpect: 100-continue\r\nContent-Length: 3\r\n\r\nabc",
+			wantBody: "abc",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := readRequest(bufio.NewReader(strings.NewReader(tt.input)), testLimits())
+			if err != nil {
+				t.Fatalf("readRequest() error = %v", err)
+			}
+			if req.expectContinue != tt.wantContinue {
+				t.Errorf("expectContinue = %v, want %v", req.expectContinue, tt.wantContinue)
+			}
+			if tt.wantContinue && len(req.Body) != 0 {
+				t.Errorf("Body = %q, want it left unread", req.Body)
+			}
+
+			started := false
+			if req.body != nil {
+				req.body.onStart = func() error { started = true; return nil }
+			}
+			body, err := io.ReadAll(req.BodyReader())
+			if err != nil {
+				t.Fatalf("BodyReader() error = %v", err)
+			}
+			if string(body) != tt.wantBody {
+				t.Errorf("BodyReader() = %q, want %q", body, tt.wantBody)
+			}
+			if started != tt.wantContinue {
+				t.Errorf("onStart called = %v, want %v", started, tt.wantContinue)
+			}
+		})
+	}
+}
+
+func TestExpectContinue_BodyErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		limits   requestLimits
+		wantErr  error
+		wantCode int
+	}{
+		{
+			name:    "Truncated body",
+			input:   "POST / HTTP/1.1\r\nExpect: 100-continue\r\nContent-Length: 10\r\n\r\nabc",
+			limits:  testLimits(),
+			wantErr: io.ErrUnexpectedEOF,
+		},
+		{
+			name:     "Chunked body over the limit",
+			input:    "POST / HTTP/1.1\r\nExpect: 100-continue\r\nTransfer-Encoding: chunked\r\n\r\nb\r\nhello world\r\n0\r\n\r\n",
+			limits:   requestLimits{maxHeaderBytes: 1024, maxBodyBytes: 5},
+			wantCode: 413,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := readRequest(bufio.NewReader(strings.NewReader(tt.input)), tt.limits)
+			if err != nil {
+				t.Fatalf("readRequest() error = %v", err)
+			}
+			_, err = io.ReadAll(req.BodyReader())
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("BodyReader() error = %v, want %v", err, tt.wantErr)
+			}
+			var se *statusError
+			if tt.wantCode != 0 && (!errors.As(err, &se) || se.code != tt.wantCode) {
+				t.Errorf("BodyReader() error = %v, want status %d", err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestRequest_From_ExpectContinue(t *testing.T) {
+	req := &Request{}
+	if err := req.From([]byte("POST / HTTP/1.1\r\nExpect: 100-continue\r\nContent-Length: 3\r\n\r\nabc")); err != nil {
+		t.Fatalf("Request.From() error = %v", err)
+	}
+	if string(req.Body) != "abc" {
+		t.Errorf("Request.From() Body = %q, want abc", req.Body)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxChunkLineBytes bounds a single chunk-size line, extensions included.
+const maxChunkLineBytes = 4096
+
+// chunkedReader decodes a body sent with Transfer-Encoding: chunked
+// (RFC 9112 section 7.1). Chunk extensions are parsed and discarded; trailer
+// fields are available in trailers once Read has returned io.EOF.
+type chunkedReader struct {
+	br       *bufio.Reader
+	n        int64 // bytes left in the current chunk
+	needCRLF bool  // the current chunk's data has been read but not its CRLF
+	err      error
+
+	maxTrailerBytes int
+	trailers        *Headers
+
+	// lenient accepts bare LF line endings and malformed trailer lines.
+	lenient bool
+}
+
+func newChunkedReader(br *bufio.Reader, maxTrailerBytes int) *chunkedReader {
+	return &chunkedReader{br: br, maxTrailerBytes: maxTrailerBytes}
+}
+
+func (cr *chunkedReader) Read(p []byte) (int, error) {
+	if cr.err != nil {
+		return 0, cr.err
+	}
+
+	if cr.n == 0 {
+		if cr.needCRLF {
+			if cr.err = cr.readCRLF(); cr.err != nil {
+				return 0, cr.err
+			}
+			cr.needCRLF = false
+		}
+
+		size, err := cr.readChunkSize()
+		if err != nil {
+			cr.err = err
+			return 0, cr.err
+		}
+		if size == 0 {
+			if cr.err = cr.readTrailers(); cr.err != nil {
+				return 0, cr.err
+			}
+			cr.err = io.EOF
+			return 0, cr.err
+		}
+		cr.n = size
+	}
+
+	if int64(len(p)) > cr.n {
+		p = p[:cr.n]
+	}
+	n, err := cr.br.Read(p)
+	cr.n -= int64(n)
+	if cr.n == 0 {
+		cr.needCRLF = true
+	}
+	if err != nil {
+		cr.err = unexpectedEOF(err)
+		return n, cr.err
+	}
+	return n, nil
+}
+
+// readChunkSize reads a chunk-size line and returns the size it declares.
+func (cr *chunkedReader) readChunkSize() (int64, error) {
+	remaining := maxChunkLineBytes
+	line, err := readLine(cr.br, &remaining, cr.lenient)
+	if err == errHeaderTooLarge {
+		return 0, newStatusError(http.StatusBadRequest, "chunk size line too long")
+	} else if err != nil {
+		return 0, unexpectedEOF(err)
+	}
+
+	// chunk-size [ BWS ";" chunk-ext ]
+	sizeField, _, _ := strings.Cut(line, ";")
+	sizeField = strings.TrimRight(sizeField, " \t")
+	if sizeField == "" || strings.ContainsAny(sizeField, "+-xX") {
+		return 0, newStatusError(http.StatusBadRequest, "invalid chunk size %q", line)
+	}
+
+	size, err := strconv.ParseInt(sizeField, 16, 64)
+	if err != nil {
+		return 0, newStatusError(http.StatusBadRequest, "invalid chunk size %q", line)
+	}
+	return size, nil
+}
+
+func (cr *chunkedReader) readCRLF() error {
+	remaining := maxChunkLineBytes
+	line, err := readLine(cr.br, &remaining, cr.lenient)
+	if err != nil && err != errHeaderTooLarge {
+		return unexpectedEOF(err)
+	}
+	if err != nil || line != "" {
+		return newStatusError(http.StatusBadRequest, "missing CRLF after chunk data")
+	}
+	return nil
+}
+
+// readTrailers reads the trailer section that follows the last chunk.
+// Fields that affect message framing or routing are not allowed in trailers
+// (RFC 9110 section 6.5.1) and are dropped.
+func (cr *chunkedReader) readTrailers() error {
+	remaining := cr.maxTrailerBytes
+	trailers, err := readHeaders(cr.br, &remaining, cr.lenient)
+	if err != nil {
+		return err
+	}
+
+	for _, k := range []string{
+		HeaderContentLength,
+		HeaderTransferEncoding,
+		HeaderTrailer,
+		HeaderHost,
+	} {
+		trailers.Del(k)
+	}
+
+	cr.trailers = trailers
+	return nil
+}
+
+// chunkedWriter encodes a body with Transfer-Encoding: chunked. Each Write
+// becomes one chunk; close writes the last chunk and the trailer section.
+type chunkedWriter struct {
+	w io.Writer
+}
+
+func (cw *chunkedWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		// A zero-length chunk would terminate the body.
+		return 0, nil
+	}
+	if _, err := fmt.Fprintf(cw.w, "%x\r\n", len(p)); err != nil {
+		return 0, fmt.Errorf("failed to write chunk size: %w", err)
+	}
+	n, err := cw.w.Write(p)
+	if err != nil {
+		return n, fmt.Errorf("failed to write chunk: %w", err)
+	}
+	if _, err := io.WriteString(cw.w, "\r\n"); err != nil {
+		return n, fmt.Errorf("failed to write chunk: %w", err)
+	}
+	return n, nil
+}
+
+func (cw *chunkedWriter) close(trailers *Headers) error {
+	var sb strings.Builder
+	sb.WriteString("0\r\n")
+	trailers.Write(&sb)
+	sb.WriteString("\r\n")
+	if _, err := io.WriteString(cw.w, sb.String()); err != nil {
+		return fmt.Errorf("failed to write last chunk: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestChunkedReader(t *testing.T) {
+	tests := []struct {
+		name         string
+		input        string
+		wantBody     string
+		wantTrailers map[string]string
+	}{
+		{
+			name:     "Single chunk",
+			input:    "5\r\nhello\r\n0\r\n\r\n",
+			wantBody: "hello",
+		},
+		{
+			name:     "Multiple chunks",
+			input:    "5\r\nhello\r\n1\r\n \r\n5\r\nworld\r\n0\r\n\r\n",
+			wantBody: "hello world",
+		},
+		{
+			name:     "Uppercase hex size",
+			input:    "A\r\n0123456789\r\n0\r\n\r\n",
+			wantBody: "0123456789",
+		},
+		{
+			name:     "Chunk extensions",
+			input:    "5;name=value\r\nhello\r\n3 ; quoted=\"a;b\"\r\nabc\r\n0;last\r\n\r\n",
+			wantBody: "helloabc",
+		},
+		{
+			name:     "Trailers",
+			input:    "3\r\nabc\r\n0\r\nX-Checksum: 900150983cd24fb0\r\nX-Other: 1\r\n\r\n",
+			wantBody: "abc",
+			wantTrailers: map[string]string{
+				"X-Checksum": "900150983cd24fb0",
+				"X-Other":    "1",
+			},
+		},
+		{
+			name:     "Empty body",
+			input:    "0\r\n\r\n",
+			wantBody: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			br := bufio.NewReader(iotest.OneByteReader(strings.NewReader(tt.input)))
+			cr := newChunkedReader(br, defaultMaxHeaderBytes)
+
+			body, err := io.ReadAll(cr)
+			if err != nil {
+				t.Fatalf("chunkedReader.Read() error = %v", err)
+			}
+			if string(body) != tt.wantBody {
+				t.Errorf("chunkedReader body = %q, want %q", body, tt.wantBody)
+			}
+
+			for k, want := range tt.wantTrailers {
+				if got, ok := cr.trailers.Get(k); !ok || got != want {
+					t.Errorf("chunkedReader trailer %s = %q, want %q", k, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestChunkedReader_Errors(t *testing.T) {
+	tests := []struct {
+		name       string
+		input      string
+		wantStatus bool
+	}{
+		{name: "Non-hex size", input: "zz\r\nhello\r\n0\r\n\r\n", wantStatus: true},
+		{name: "Empty size", input: "\r\nhello\r\n0\r\n\r\n", wantStatus: true},
+		{name: "Signed size", input: "+5\r\nhello\r\n0\r\n\r\n", wantStatus: true},
+		{name: "Hex prefix", input: "0x5\r\nhello\r\n0\r\n\r\n", wantStatus: true},
+		{name: "Overflowing size", input: "fffffffffffffffff\r\n", wantStatus: true},
+		{name: "Missing CRLF after data", input: "5\r\nhelloX\r\n0\r\n\r\n", wantStatus: true},
+		{name: "Truncated data", input: "5\r\nhel", wantStatus: false},
+		{name: "Missing last chunk", input: "5\r\nhello\r\n", wantStatus: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := newChunkedReader(bufio.NewReader(strings.NewReader(tt.input)), defaultMaxHeaderBytes)
+			_, err := io.ReadAll(cr)
+			if err == nil {
+				t.Fatal("chunkedReader.Read() error = nil, want error")
+			}
+
+			var se *statusError
+			if tt.wantStatus && !errors.As(err, &se) {
+				t.Errorf("chunkedReader.Read() error = %v, want *statusError", err)
+			}
+			if !tt.wantStatus && !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("chunkedReader.Read() error = %v, want io.ErrUnexpectedEOF", err)
+			}
+		})
+	}
+}
+
+func TestReadRequest_Chunked(t *testing.T) {
+	raw := "POST /files/log HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"4\r\nWiki\r\n5\r\npedia\r\n0\r\nX-Digest: abc\r\nContent-Length: 100\r\n\r\n" +
+		"GET / HTTP/1.1\r\n\r\n"
+	br := bufio.NewReader(strings.NewReader(raw))
+
+	req, err := readRequest(br, testLimits())
+	if err != nil {
+		t.Fatalf("readRequest() error = %v", err)
+	}
+	if string(req.Body) != "Wikipedia" {
+		t.Errorf("readRequest() body = %q, want Wikipedia", req.Body)
+	}
+	if v, _ := req.Trailers.Get("X-Digest"); v != "abc" {
+		t.Errorf("readRequest() trailer X-Digest = %q, want abc", v)
+	}
+	if _, ok := req.Trailers.Get(HeaderContentLength); ok {
+		t.Error("readRequest() should drop Content-Length from trailers")
+	}
+
+	next, err := readRequest(br, testLimits())
+	if err != nil {
+		t.Fatalf("readRequest() next error = %v", err)
+	}
+	if next.Method != "GET" {
+		t.Errorf("readRequest() next method = %q, want GET", next.Method)
+	}
+}
+
+func TestReadRequest_TransferEncodingErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		limits   requestLimits
+		wantCode int
+	}{
+		{
+			name:     "Content-Length with Transfer-Encoding",
+			input:    "POST / HTTP/1.1\r\nContent-Length: 5\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n",
+			limits:   testLimits(),
+			wantCode: 400,
+		},
+		{
+			name:     "Chunked not final",
+			input:    "POST / HTTP/1.1\r\nTransfer-Encoding: chunked, gzip\r\n\r\n",
+			limits:   testLimits(),
+			wantCode: 400,
+		},
+		{
+			name:     "Unknown coding",
+			input:    "POST / HTTP/1.1\r\nTransfer-Encoding: identity\r\n\r\n",
+			limits:   testLimits(),
+			wantCode: 400,
+		},
+		{
+			name:     "Unsupported coding before chunked",
+			input:    "POST / HTTP/1.1\r\nTransfer-Encoding: gzip, chunked\r\n\r\n",
+			limits:   testLimits(),
+			wantCode: 501,
+		},
+		{
+			name:     "Transfer-Encoding in HTTP/1.0",
+			input:    "POST / HTTP/1.0\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n",
+			limits:   testLimits(),
+			wantCode: 400,
+		},
+		{
+			name:     "Decoded body too large",
+			input:    "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n8\r\n01234567\r\n8\r\n01234567\r\n0\r\n\r\n",
+			limits:   requestLimits{maxHeaderBytes: 1024, maxBodyBytes: 10},
+			wantCode: 413,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readRequest(bufio.NewReader(strings.NewReader(tt.input)), tt.limits)
+			var se *statusError
+			if !errors.As(err, &se) {
+				t.Fatalf("readRequest() error = %v, want *statusError", err)
+			}
+			if se.code != tt.wantCode {
+				t.Errorf("readRequest() status = %d, want %d", se.code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestChunkedWriter(t *testing.T) {
+	var buf bytes.Buffer
+	cw := &chunkedWriter{w: &buf}
+	cw.Write([]byte("hello"))
+	cw.Write(nil)
+	cw.Write([]byte(" world!"))
+
+	trailers := NewHeaders()
+	trailers.Set("X-Checksum", "abc")
+	if err := cw.close(trailers); err != nil {
+		t.Fatalf("chunkedWriter.close() error = %v", err)
+	}
+
+	want := "5\r\nhello\r\n7\r\n world!\r\n0\r\nX-Checksum: abc\r\n\r\n"
+	if buf.String() != want {
+		t.Errorf("chunkedWriter output = %q, want %q", buf.String(), want)
+	}
+
+	// What the writer produces, the reader must accept.
+	cr := newChunkedReader(bufio.NewReader(&buf), defaultMaxHeaderBytes)
+	body, err := io.ReadAll(cr)
+	if err != nil {
+		t.Fatalf("chunkedReader.Read() error = %v", err)
+	}
+	if string(body) != "hello world!" {
+		t.Errorf("round trip body = %q, want %q", body, "hello world!")
+	}
+	if v, _ := cr.trailers.Get("X-Checksum"); v != "abc" {
+		t.Errorf("round trip trailer = %q, want abc", v)
+	}
+}
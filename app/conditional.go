@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// ETag modes, selecting how file entity tags are generated.
+const (
+	// ETagModTime derives a strong tag from the file's size and
+	// modification time. It is cheap but assumes a file is never changed
+	// twice within the file system's timestamp resolution.
+	ETagModTime = "modtime"
+	// ETagWeak derives the same tag but marks it weak, so that it is only
+	// used for cache revalidation and never for ranges or writes.
+	ETagWeak = "weak"
+	// ETagHash hashes the file's content, which reads the whole file for
+	// every request.
+	ETagHash = "hash"
+)
+
+// fileETag returns the entity tag of f, which has the given info, according
+// to the server's etagMode. Hashing leaves f positioned at its start.
+func (s *server) fileETag(f *os.File, info os.FileInfo) (string, error) {
+	switch s.etagMode {
+	case "", ETagModTime:
+		return modTimeETag(info), nil
+	case ETagWeak:
+		return "W/" + modTimeETag(info), nil
+	case ETagHash:
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return "", fmt.Errorf("failed to hash file: %w", err)
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return "", fmt.Errorf("failed to rewind file: %w", err)
+		}
+		return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`, nil
+	}
+	return "", fmt.Errorf("unknown ETag mode %q", s.etagMode)
+}
+
+func modTimeETag(info os.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.Size(), info.ModTime().UnixNano())
+}
+
+// checkPreconditions evaluates the conditional headers of req (RFC 9110
+// section 13.2.2) against a resource with the given entity tag and
+// modification time, and returns the status code to answer with instead of
+// performing the request: 304 Not Modified, 412 Precondition Failed, or 0
+// if the request should proceed. exists is false when the resource does
+// not exist yet, as for an upload creating it.
+func checkPreconditions(req *Request, etag string, modTime time.Time, exists bool) int {
+	modTime = modTime.Truncate(time.Second)
+	safe := req.Method == http.MethodGet || req.Method == http.MethodHead
+
+	if v, ok := req.Headers.Get(HeaderIfMatch); ok {
+		if !exists || !etagListMatches(v, etag, false) {
+			return http.StatusPreconditionFailed
+		}
+	} else if t, ok := headerTime(req, HeaderIfUnmodifiedSince); ok && exists && modTime.After(t) {
+		return http.StatusPreconditionFailed
+	}
+
+	if v, ok := req.Headers.Get(HeaderIfNoneMatch); ok {
+		if exists && etagListMatches(v, etag, true) {
+			if safe {
+				return http.StatusNotModified
+			}
+			return http.StatusPreconditionFailed
+		}
+	} else if t, ok := headerTime(req, HeaderIfModifiedSince); ok && safe && exists && !modTime.After(t) {
+		return http.StatusNotModified
+	}
+
+	return 0
+}
+
+// headerTime returns the HTTP-date in header k of req. Invalid dates are
+// ignored, as RFC 9110 requires.
+func headerTime(req *Request, k string) (time.Time, bool) {
+	v, ok := req.Headers.Get(k)
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := http.ParseTime(v)
+	return t, err == nil
+}
+
+// etagListMatches reports whether the If-Match or If-None-Match value list
+// matches etag, using the weak comparison function if weak is set and the
+// strong one otherwise. "*" matches any tag.
+func etagListMatches(list, etag string, weak bool) bool {
+	list = strings.TrimSpace(list)
+	if list == "*" {
+		return true
+	}
+	for list != "" {
+		tag, rest, ok := scanETag(list)
+		if !ok {
+			return false
+		}
+		if weak && weakETagMatch(tag, etag) || !weak && strongETagMatch(tag, etag) {
+			return true
+		}
+		list = strings.TrimLeft(rest, " \t,")
+	}
+	return false
+}
+
+// scanETag splits the entity tag at the start of s from the rest of s.
+// Tags are scanned rather than split on commas because they may contain
+// commas.
+func scanETag(s string) (tag, rest string, ok bool) {
+	start := 0
+	if strings.HasPrefix(s, "W/") {
+		start = 2
+	}
+	if len(s) < start+2 || s[start] != '"' {
+		return "", "", false
+	}
+	end := strings.IndexByte(s[start+1:], '"')
+	if end < 0 {
+		return "", "", false
+	}
+	end += start + 2
+	return s[:end], s[end:], true
+}
+
+// strongETagMatch reports whether a and b are the same strong entity tag.
+func strongETagMatch(a, b string) bool {
+	return a == b && !strings.HasPrefix(a, "W/")
+}
+
+// weakETagMatch reports whether a and b are the same entity tag, ignoring
+// whether either is weak.
+func weakETagMatch(a, b string) bool {
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
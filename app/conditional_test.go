@@ -0,0 +1,165 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestEtagListMatches(t *testing.T) {
+	tests := []struct {
+		list string
+		etag string
+		weak bool
+		want bool
+	}{
+		{list: `"abc"`, etag: `"abc"`, want: true},
+		{list: `"abc"`, etag: `"abd"`, want: false},
+		{list: `"x", "abc"`, etag: `"abc"`, want: true},
+		{list: `"a,b", "c"`, etag: `"a,b"`, want: true},
+		{list: `*`, etag: `"abc"`, want: true},
+		{list: `W/"abc"`, etag: `"abc"`, want: false},
+		{list: `W/"abc"`, etag: `"abc"`, weak: true, want: true},
+		{list: `"abc"`, etag: `W/"abc"`, weak: true, want: true},
+		{list: `"abc"`, etag: `W/"abc"`, want: false},
+		{list: `abc`, etag: `"abc"`, weak: true, want: false},
+	}
+
+	for _, tt := range tests {
+		if got := etagListMatches(tt.list, tt.etag, tt.weak); got != tt.want {
+			t.Errorf("etagListMatches(%s, %s, weak=%v) = %v, want %v", tt.list, tt.etag, tt.weak, got, tt.want)
+		}
+	}
+}
+
+func TestCheckPreconditions(t *testing.T) {
+	modTime := time.Date(2024, time.January, 2, 3, 4, 5, 500, time.UTC)
+	etag := `"v1"`
+	before := modTime.Add(-time.Hour).Format(http.TimeFormat)
+	at := modTime.Format(http.TimeFormat)
+
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		exists  bool
+		want    int
+	}{
+		{name: "No conditions", exists: true, want: 0},
+		{name: "If-None-Match matches", headers: map[string]string{"If-None-Match": `"v0", "v1"`}, exists: true, want: 304},
+		{name: "If-None-Match matches weakly", headers: map[string]string{"If-None-Match": `W/"v1"`}, exists: true, want: 304},
+		{name: "If-None-Match differs", headers: map[string]string{"If-None-Match": `"v0"`}, exists: true, want: 0},
+		{name: "If-None-Match on unsafe method", method: "PUT", headers: map[string]string{"If-None-Match": `*`}, exists: true, want: 412},
+		{name: "If-None-Match star on missing resource", method: "PUT", headers: map[string]string{"If-None-Match": `*`}, want: 0},
+		{name: "If-Modified-Since not modified", headers: map[string]string{"If-Modified-Since": at}, exists: true, want: 304},
+		{name: "If-Modified-Since modified", headers: map[string]string{"If-Modified-Since": before}, exists: true, want: 0},
+		{name: "If-Modified-Since invalid date", headers: map[string]string{"If-Modified-Since": "yesterday"}, exists: true, want: 0},
+		{name: "If-None-Match overrides If-Modified-Since", headers: map[string]string{"If-None-Match": `"v0"`, "If-Modified-Since": at}, exists: true, want: 0},
+		{name: "If-Match matches", headers: map[string]string{"If-Match": `"v1"`}, exists: true, want: 0},
+		{name: "If-Match differs", headers: map[string]string{"If-Match": `"v0"`}, exists: true, want: 412},
+		{name: "If-Match weak tag", headers: map[string]string{"If-Match": `W/"v1"`}, exists: true, want: 412},
+		{name: "If-Match star on missing resource", headers: map[string]string{"If-Match": `*`}, want: 412},
+		{name: "If-Unmodified-Since modified", headers: map[string]string{"If-Unmodified-Since": before}, exists: true, want: 412},
+		{name: "If-Unmodified-Since unmodified", headers: map[string]string{"If-Unmodified-Since": at}, exists: true, want: 0},
+		{name: "If-Match overrides If-Unmodified-Since", headers: map[string]string{"If-Match": `"v1"`, "If-Unmodified-Since": before}, exists: true, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = "GET"
+			}
+			req := createTestRequest(method, "/files/x", "HTTP/1.1", tt.headers, nil)
+			if got := checkPreconditions(req, etag, modTime, tt.exists); got != tt.want {
+				t.Errorf("checkPreconditions() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_FileETag(t *testing.T) {
+	tests := []struct {
+		mode string
+		want *regexp.Regexp
+	}{
+		{mode: "", want: regexp.MustCompile(`^"5-[0-9a-f]+"$`)},
+		{mode: ETagWeak, want: regexp.MustCompile(`^W/"5-[0-9a-f]+"$`)},
+		// The first 16 bytes of the SHA-256 of "hello".
+		{mode: ETagHash, want: regexp.MustCompile(`^"2cf24dba5fb0a30e26e83b2ac5b9e29e"$`)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			server := createTestServer(t)
+			server.etagMode = tt.mode
+			path := filepath.Join(server.dir, "f")
+			if err := os.WriteFile(path, []byte("hello"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			info, _ := f.Stat()
+
+			etag, err := server.fileETag(f, info)
+			if err != nil {
+				t.Fatalf("fileETag() error = %v", err)
+			}
+			if !tt.want.MatchString(etag) {
+				t.Errorf("fileETag() = %s, want match for %s", etag, tt.want)
+			}
+			if pos, _ := f.Seek(0, 1); pos != 0 {
+				t.Errorf("file offset after fileETag() = %d, want 0", pos)
+			}
+		})
+	}
+}
+
+func TestFilesGet_Conditional(t *testing.T) {
+	server := createTestServer(t)
+	if err := os.WriteFile(filepath.Join(server.dir, "c.txt"), []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, _ := getFile(t, server, "GET", "c.txt", nil)
+	etag := resp.Header.Get("ETag")
+	lastModified := resp.Header.Get("Last-Modified")
+	if etag == "" || lastModified == "" {
+		t.Fatalf("ETag = %q, Last-Modified = %q, want both set", etag, lastModified)
+	}
+
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		wantCode int
+		wantBody string
+	}{
+		{name: "Revalidate by ETag", headers: map[string]string{"If-None-Match": etag}, wantCode: 304},
+		{name: "Revalidate by date", headers: map[string]string{"If-Modified-Since": lastModified}, wantCode: 304},
+		{name: "Stale ETag", headers: map[string]string{"If-None-Match": `"old"`}, wantCode: 200, wantBody: "content"},
+		{name: "If-Match fails", headers: map[string]string{"If-Match": `"old"`}, wantCode: 412},
+		{name: "If-Range with current ETag", headers: map[string]string{"Range": "bytes=0-2", "If-Range": etag}, wantCode: 206, wantBody: "con"},
+		{name: "If-Range with old ETag", headers: map[string]string{"Range": "bytes=0-2", "If-Range": `"old"`}, wantCode: 200, wantBody: "content"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := getFile(t, server, "GET", "c.txt", tt.headers)
+			if resp.StatusCode != tt.wantCode {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantCode)
+			}
+			if body != tt.wantBody {
+				t.Errorf("body = %q, want %q", body, tt.wantBody)
+			}
+			if got := resp.Header.Get("ETag"); got != etag {
+				t.Errorf("ETag = %q, want %q", got, etag)
+			}
+		})
+	}
+}
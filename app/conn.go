@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultReadTimeout       = 30 * time.Second
+	defaultWriteTimeout      = 30 * time.Second
+	defaultIdleTimeout       = 30 * time.Second
+	defaultHandlerTimeout    = 30 * time.Second
+)
+
+// timeouts holds the effective connection timeouts of a server. A zero
+// duration means no timeout.
+type timeouts struct {
+	readHeader time.Duration
+	read       time.Duration
+	write      time.Duration
+	idle       time.Duration
+	handler    time.Duration
+}
+
+// timeouts applies the defaults to the server's timeout settings: zero
+// means the default and a negative duration means no timeout.
+func (s *server) timeouts() timeouts {
+	or := func(d, def time.Duration) time.Duration {
+		switch {
+		case d < 0:
+			return 0
+		case d == 0:
+			return def
+		}
+		return d
+	}
+	return timeouts{
+		readHeader: or(s.readHeaderTimeout, defaultReadHeaderTimeout),
+		read:       or(s.readTimeout, defaultReadTimeout),
+		write:      or(s.writeTimeout, defaultWriteTimeout),
+		idle:       or(s.idleTimeout, defaultIdleTimeout),
+		handler:    or(s.handlerTimeout, defaultHandlerTimeout),
+	}
+}
+
+// deadline returns the deadline for an operation starting now that may take
+// d, or the zero time if d is zero.
+func deadline(d time.Duration) time.Time {
+	if d <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(d)
+}
+
+// readNextRequest waits up to the idle timeout for the next request to
+// start, then reads its head within the read-header timeout and, unless the
+// body is read on demand, its body within the read timeout. While waiting
+// the connection is idle and may be closed by Shutdown.
+func (s *server) readNextRequest(conn net.Conn, br *bufio.Reader, limits requestLimits, t timeouts) (*Request, error) {
+	if br.Buffered() == 0 {
+		if !s.setConnState(conn, stateIdle) {
+			return nil, errServerClosed
+		}
+		if err := conn.SetReadDeadline(deadline(t.idle)); err != nil {
+			return nil, err
+		}
+		if _, err := br.Peek(1); err != nil {
+			return nil, err
+		}
+		s.setConnState(conn, stateActive)
+	}
+
+	// The header deadline is fixed when the request starts, so a client
+	// trickling header bytes cannot keep the connection open.
+	if err := conn.SetReadDeadline(deadline(t.readHeader)); err != nil {
+		return nil, err
+	}
+	req, err := readRequestHead(br, limits)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.SetReadDeadline(deadline(t.read)); err != nil {
+		return nil, err
+	}
+	if err := req.readBody(br, limits); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// deadlineWriter sets a fresh write deadline before every write to conn.
+// A client that stops reading is dropped after the timeout, while a slow
+// download that keeps making progress is never cut off.
+type deadlineWriter struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (w deadlineWriter) Write(p []byte) (int, error) {
+	if err := w.conn.SetWriteDeadline(deadline(w.timeout)); err != nil {
+		return 0, err
+	}
+	return w.conn.Write(p)
+}
+
+// connReader is the reader under a connection's bufio.Reader. While a
+// handler runs it keeps a one-byte read pending on the connection, so that
+// the handler's context is cancelled as soon as the client disconnects. A
+// byte that arrives meanwhile belongs to the next request and is returned
+// by the next Read.
+type connReader struct {
+	conn net.Conn
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	inRead  bool // a background read is pending
+	aborted bool // the pending read was stopped by abortPendingRead
+	hasByte bool
+	byteBuf [1]byte
+	err     error // error from the background read, returned by Read
+}
+
+func newConnReader(conn net.Conn) *connReader {
+	cr := &connReader{conn: conn}
+	cr.cond = sync.NewCond(&cr.mu)
+	return cr
+}
+
+func (cr *connReader) Read(p []byte) (int, error) {
+	cr.mu.Lock()
+	if cr.inRead {
+		cr.mu.Unlock()
+		return 0, errors.New("concurrent read on connection")
+	}
+	if cr.hasByte && len(p) > 0 {
+		p[0] = cr.byteBuf[0]
+		cr.hasByte = false
+		cr.mu.Unlock()
+		return 1, nil
+	}
+	if err := cr.err; err != nil {
+		cr.mu.Unlock()
+		return 0, err
+	}
+	cr.mu.Unlock()
+	return cr.conn.Read(p)
+}
+
+// startBackgroundRead starts watching the connection, calling cancel if the
+// client disconnects. It must only be used while nothing else reads from
+// the connection.
+func (cr *connReader) startBackgroundRead(cancel context.CancelFunc) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	if cr.inRead || cr.hasByte || cr.err != nil {
+		return
+	}
+	cr.inRead = true
+	cr.conn.SetReadDeadline(time.Time{})
+	go cr.backgroundRead(cancel)
+}
+
+func (cr *connReader) backgroundRead(cancel context.CancelFunc) {
+	n, err := cr.conn.Read(cr.byteBuf[:])
+
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	if n == 1 {
+		cr.hasByte = true
+	}
+	var netErr net.Error
+	if cr.aborted && errors.As(err, &netErr) && netErr.Timeout() {
+		// Stopped by abortPendingRead rather than by the client.
+	} else if err != nil {
+		cr.err = err
+		cancel()
+	}
+	cr.aborted = false
+	cr.inRead = false
+	cr.cond.Broadcast()
+}
+
+// abortPendingRead stops a background read and waits for it to finish.
+func (cr *connReader) abortPendingRead() {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	if !cr.inRead {
+		return
+	}
+	cr.aborted = true
+	cr.conn.SetReadDeadline(time.Unix(1, 0))
+	for cr.inRead {
+		cr.cond.Wait()
+	}
+	cr.conn.SetReadDeadline(time.Time{})
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_Timeouts_Defaults(t *testing.T) {
+	s := &server{readTimeout: -1, writeTimeout: 5 * time.Second}
+	got := s.timeouts()
+	want := timeouts{
+		readHeader: defaultReadHeaderTimeout,
+		read:       0,
+		write:      5 * time.Second,
+		idle:       defaultIdleTimeout,
+		handler:    defaultHandlerTimeout,
+	}
+	if got != want {
+		t.Errorf("timeouts() = %+v, want %+v", got, want)
+	}
+}
+
+// serveConn runs handleConn on one end of a pipe and returns the other end
+// and a channel receiving handleConn's result.
+func serveConn(t *testing.T, server *server) (net.Conn, <-chan error) {
+	client, conn := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+	done := make(chan error, 1)
+	go func() {
+		done <- server.handleConn(conn)
+	}()
+	return client, done
+}
+
+// waitClosed fails the test unless handleConn returns within limit.
+func waitClosed(t *testing.T, done <-chan error, limit time.Duration) error {
+	t.Helper()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(limit):
+		t.Fatalf("connection still open after %s", limit)
+		return nil
+	}
+}
+
+func TestHandleConn_HandlerContextCancelledOnDisconnect(t *testing.T) {
+	server := createTestServer(t)
+	started := make(chan struct{})
+	ctxErr := make(chan error, 1)
+	server.Register("GET", "/wait", func(ctx context.Context, req *Request, w ResponseWriter) error {
+		close(started)
+		select {
+		case <-ctx.Done():
+			ctxErr <- ctx.Err()
+		case <-time.After(5 * time.Second):
+			ctxErr <- nil
+		}
+		return nil
+	})
+
+	client, _ := serveConn(t, server)
+	if _, err := client.Write([]byte("GET /wait HTTP/1.1\r\n\r\n")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	<-started
+	client.Close()
+
+	if err := <-ctxErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("handler context error = %v, want context.Canceled", err)
+	}
+}
+
+func TestHandleConn_HandlerTimeout(t *testing.T) {
+	server := createTestServer(t)
+	server.handlerTimeout = 50 * time.Millisecond
+	server.Register("GET", "/wait", func(ctx context.Context, req *Request, w ResponseWriter) error {
+		<-ctx.Done()
+		return httpResponse(w, http.StatusServiceUnavailable, ctx.Err().Error())
+	})
+
+	client, _ := serveConn(t, server)
+	client.SetDeadline(time.Now().Add(2 * time.Second))
+	go client.Write([]byte("GET /wait HTTP/1.1\r\n\r\n"))
+
+	resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+	if err != nil {
+		t.Fatalf("ReadResponse() error = %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusServiceUnavailable || string(body) != context.DeadlineExceeded.Error() {
+		t.Errorf("response = %d %q, want 503 %q", resp.StatusCode, body, context.DeadlineExceeded)
+	}
+}
+
+func TestHandleConn_ReadHeaderTimeout(t *testing.T) {
+	server := createTestServer(t)
+	server.readHeaderTimeout = 100 * time.Millisecond
+	server.Register("GET", "/{$}", server.rootGet)
+
+	client, done := serveConn(t, server)
+
+	// Trickle the header one byte at a time, slower than the timeout allows
+	// in total but fast enough to defeat a per-read deadline.
+	go func() {
+		for _, b := range []byte("GET / HTTP/1.1\r\nX-Slow: " + strings.Repeat("a", 100)) {
+			if _, err := client.Write([]byte{b}); err != nil {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	waitClosed(t, done, time.Second)
+}
+
+func TestHandleConn_IdleTimeout(t *testing.T) {
+	server := createTestServer(t)
+	server.idleTimeout = 50 * time.Millisecond
+	server.Register("GET", "/{$}", server.rootGet)
+
+	client, done := serveConn(t, server)
+	go client.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+	if err != nil {
+		t.Fatalf("ReadResponse() error = %v", err)
+	}
+	if resp.Close {
+		t.Fatal("response closed the connection, want keep-alive")
+	}
+
+	if err := waitClosed(t, done, time.Second); err != nil {
+		t.Errorf("handleConn() error = %v", err)
+	}
+}
+
+func TestHandleConn_WriteTimeout(t *testing.T) {
+	server := createTestServer(t)
+	server.writeTimeout = 50 * time.Millisecond
+	server.Register("GET", "/big", func(ctx context.Context, req *Request, w ResponseWriter) error {
+		chunk := []byte(strings.Repeat("x", 32*1024))
+		for range 64 {
+			if _, err := w.Write(chunk); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	client, done := serveConn(t, server)
+	client.Write([]byte("GET /big HTTP/1.1\r\n\r\n"))
+
+	// The client never reads the response.
+	if err := waitClosed(t, done, 2*time.Second); err == nil {
+		t.Error("handleConn() error = nil, want write timeout")
+	}
+}
+
+func TestHandleConn_SlowDownloadKeepsGoing(t *testing.T) {
+	server := createTestServer(t)
+	server.writeTimeout = 100 * time.Millisecond
+	body := strings.Repeat("y", 256*1024)
+	server.Register("GET", "/big", func(ctx context.Context, req *Request, w ResponseWriter) error {
+		for i := 0; i < len(body); i += 8 * 1024 {
+			if _, err := w.Write([]byte(body[i : i+8*1024])); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	client, _ := serveConn(t, server)
+	go client.Write([]byte("GET /big HTTP/1.1\r\n\r\n"))
+
+	// Reading in small pieces takes far longer than the write timeout in
+	// total, but every write makes progress within it.
+	br := bufio.NewReaderSize(client, 16)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("ReadResponse() error = %v", err)
+	}
+	var got strings.Builder
+	buf := make([]byte, 16*1024)
+	for {
+		n, err := resp.Body.Read(buf)
+		got.Write(buf[:n])
+		if err != nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if got.Len() != len(body) {
+		t.Errorf("downloaded %d bytes, want %d", got.Len(), len(body))
+	}
+}
+
+func TestHandleConn_NextRequestDuringHandler(t *testing.T) {
+	server := createTestServer(t)
+	started := make(chan struct{}, 2)
+	server.Register("GET", "/echo/{text...}", func(ctx context.Context, req *Request, w ResponseWriter) error {
+		started <- struct{}{}
+		time.Sleep(50 * time.Millisecond)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return server.echoGet(ctx, req, w)
+	})
+
+	client, _ := serveConn(t, server)
+	client.SetDeadline(time.Now().Add(2 * time.Second))
+	go func() {
+		client.Write([]byte("GET /echo/one HTTP/1.1\r\n\r\n"))
+		// The second request arrives while the first handler runs, so its
+		// first byte is taken by the disconnect watcher.
+		<-started
+		client.Write([]byte("GET /echo/two HTTP/1.1\r\nConnection: close\r\n\r\n"))
+	}()
+
+	br := bufio.NewReader(client)
+	for _, want := range []string{"one", "two"} {
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatalf("ReadResponse() error = %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if string(body) != want {
+			t.Errorf("response body = %q, want %q", body, want)
+		}
+	}
+}
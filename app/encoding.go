@@ -18,7 +18,7 @@ type Encoder interface {
 }
 
 func encoderFromRequest(r *Request) Encoder {
-	e, ok := r.Headers.Get(HeaderAcceptEncoding)
+	e, ok := r.Headers.joined(HeaderAcceptEncoding)
 	if !ok {
 		return nil
 	}
@@ -46,7 +46,7 @@ func TestEncoderFromRequest(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			req := &Request{
-				Headers: make(Headers),
+				Headers: NewHeaders(),
 			}
 
 			if tt.acceptEncoding != "" {
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	errInvalidPath = errors.New("invalid file path")
+	errOutsideRoot = errors.New("path escapes the file root")
+)
+
+// fileRoot confines file access to a directory. Names are slash-separated
+// and relative to the directory; names that are absolute, contain NUL bytes
+// or ".." segments are rejected before touching the file system, and the
+// remaining ones are opened through os.Root so that symlinks cannot lead
+// outside the directory either.
+type fileRoot struct {
+	dir string
+}
+
+func (s *server) files() fileRoot {
+	return fileRoot{dir: s.dir}
+}
+
+// cleanName validates name and returns it as a clean relative path.
+func (fr fileRoot) cleanName(name string) (string, error) {
+	if strings.ContainsRune(name, 0) {
+		return "", fmt.Errorf("%w: NUL byte in %q", errInvalidPath, name)
+	}
+	if strings.HasPrefix(name, "/") || strings.Contains(name, `\`) || filepath.IsAbs(name) {
+		return "", fmt.Errorf("%w: %q is not relative", errInvalidPath, name)
+	}
+	for _, seg := range strings.Split(name, "/") {
+		if seg == ".." {
+			return "", fmt.Errorf("%w: %q contains '..'", errInvalidPath, name)
+		}
+	}
+	return filepath.FromSlash(path.Clean("/" + name)[1:]), nil
+}
+
+// cleanFileName is cleanName for the name of a file to write, which must
+// not be empty or end in a slash like the name of a directory.
+func (fr fileRoot) cleanFileName(name string) (string, error) {
+	clean, err := fr.cleanName(name)
+	if err == nil && (clean == "" || strings.HasSuffix(name, "/")) {
+		err = fmt.Errorf("%w: %q is not a file name", errInvalidPath, name)
+	}
+	return clean, err
+}
+
+// open opens name for reading.
+func (fr fileRoot) open(name string) (*os.File, error) {
+	return fr.openFile(name, os.O_RDONLY, 0)
+}
+
+// openAppend opens name for appending, creating it if it does not exist.
+func (fr fileRoot) openAppend(name string) (*os.File, error) {
+	return fr.openFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+}
+
+// remove removes the file or empty directory name.
+func (fr fileRoot) remove(name string) error {
+	clean, err := fr.cleanName(name)
+	if err != nil {
+		return err
+	}
+
+	root, err := os.OpenRoot(fr.dir)
+	if err != nil {
+		return fmt.Errorf("failed to open file root: %w", err)
+	}
+	defer root.Close()
+
+	err = root.Remove(clean)
+	if isPathEscape(err) {
+		return fmt.Errorf("%w: %q", errOutsideRoot, name)
+	}
+	return err
+}
+
+func (fr fileRoot) openFile(name string, flag int, perm os.FileMode) (*os.File, error) {
+	clean, err := fr.cleanName(name)
+	if err != nil {
+		return nil, err
+	}
+	if clean == "" {
+		clean = "."
+	}
+
+	root, err := os.OpenRoot(fr.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file root: %w", err)
+	}
+	defer root.Close()
+
+	f, err := root.OpenFile(clean, flag, perm)
+	if isPathEscape(err) {
+		return nil, fmt.Errorf("%w: %q", errOutsideRoot, name)
+	}
+	return f, err
+}
+
+// isPathEscape reports whether err is os.Root refusing to follow a path
+// outside of the root. The os package does not export this error, so it is
+// recognised by its message.
+func isPathEscape(err error) bool {
+	var pathErr *fs.PathError
+	return errors.As(err, &pathErr) && pathErr.Err.Error() == "path escapes from parent"
+}
+
+// fileErrorStatus maps an error from fileRoot, or from reading the request
+// body into a file, to a response status.
+func fileErrorStatus(err error) int {
+	var se *statusError
+	switch {
+	case errors.As(err, &se):
+		return se.code
+	case errors.Is(err, errInvalidPath):
+		return http.StatusBadRequest
+	case errors.Is(err, errOutsideRoot):
+		return http.StatusForbidden
+	case errors.Is(err, fs.ErrNotExist):
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
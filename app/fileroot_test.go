@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileRoot_CleanName(t *testing.T) {
+	fr := fileRoot{dir: t.TempDir()}
+
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{name: "Plain file", input: "a.txt", want: "a.txt"},
+		{name: "Nested file", input: "dir/a.txt", want: filepath.Join("dir", "a.txt")},
+		{name: "Dot segments", input: "./dir//a.txt", want: filepath.Join("dir", "a.txt")},
+		{name: "Parent segment", input: "../etc/passwd", wantErr: true},
+		{name: "Parent segment in the middle", input: "dir/../../etc/passwd", wantErr: true},
+		{name: "Only parent", input: "..", wantErr: true},
+		{name: "Absolute path", input: "/etc/passwd", wantErr: true},
+		{name: "Backslash", input: `..\etc\passwd`, wantErr: true},
+		{name: "NUL byte", input: "a.txt\x00.png", wantErr: true},
+		{name: "Dots in a name are fine", input: "a..b", want: "a..b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := fr.cleanName(tt.input)
+			if tt.wantErr {
+				if !errors.Is(err, errInvalidPath) {
+					t.Errorf("cleanName(%q) error = %v, want errInvalidPath", tt.input, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("cleanName(%q) error = %v", tt.input, err)
+			}
+			if got != tt.want {
+				t.Errorf("cleanName(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+// setupEscapingRoot creates a server directory holding a file, a symlink to
+// a file outside the directory and a symlink to a file inside it. It returns
+// the server and the outside directory.
+func setupEscapingRoot(t *testing.T) (*server, string) {
+	server := createTestServer(t)
+	outside := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0o644); err != nil {
+		t.Fatalf("Failed to create outside file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(server.dir, "test.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	links := map[string]string{
+		"escape.txt": filepath.Join(outside, "secret.txt"),
+		"escapedir":  outside,
+		"alias.txt":  "test.txt",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(server.dir, name)); err != nil {
+			t.Skipf("symlinks not supported: %v", err)
+		}
+	}
+	return server, outside
+}
+
+func TestFilesGet_Traversal(t *testing.T) {
+	server, _ := setupEscapingRoot(t)
+
+	tests := []struct {
+		name     string
+		target   string
+		wantCode int
+		wantBody string
+	}{
+		{name: "Encoded parent", target: "/files/%2e%2e%2fetc%2fpasswd", wantCode: 400},
+		{name: "Mixed encoding", target: "/files/..%2f..%2fetc%2fpasswd", wantCode: 400},
+		{name: "Encoded backslash", target: "/files/..%5cetc%5cpasswd", wantCode: 400},
+		{name: "Encoded absolute path", target: "/files/%2fetc%2fpasswd", wantCode: 400},
+		{name: "Encoded NUL byte", target: "/files/test.txt%00.png", wantCode: 400},
+		{name: "Symlink outside root", target: "/files/escape.txt", wantCode: 403},
+		{name: "Through symlinked directory", target: "/files/escapedir/secret.txt", wantCode: 403},
+		{name: "Symlink inside root", target: "/files/alias.txt", wantCode: 200, wantBody: "hello"},
+		{name: "Encoded name inside root", target: "/files/%74est.txt", wantCode: 200, wantBody: "hello"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := createTestRequest("GET", tt.target, "HTTP/1.1", nil, nil)
+			buf := captureResponse(t, server.filesGet, withPathValue(req, "name", "/files/"))
+			statusCode, _, body := parseHTTPResponse(buf.String())
+
+			if statusCode != tt.wantCode {
+				t.Errorf("filesGet(%s) status = %v, want %v", tt.target, statusCode, tt.wantCode)
+			}
+			if tt.wantBody != "" && body != tt.wantBody {
+				t.Errorf("filesGet(%s) body = %q, want %q", tt.target, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestFilesPost_Traversal(t *testing.T) {
+	server, outside := setupEscapingRoot(t)
+
+	tests := []struct {
+		name     string
+		target   string
+		wantCode int
+	}{
+		{name: "Encoded parent", target: "/files/%2e%2e%2fescaped.txt", wantCode: 400},
+		{name: "Plain parent", target: "/files/../escaped.txt", wantCode: 400},
+		{name: "Through symlinked directory", target: "/files/escapedir/escaped.txt", wantCode: 403},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := createTestRequest("POST", tt.target, "HTTP/1.1", nil, []byte("pwned"))
+			buf := captureResponse(t, server.filesPost, withPathValue(req, "name", "/files/"))
+			statusCode, _, _ := parseHTTPResponse(buf.String())
+
+			if statusCode != tt.wantCode {
+				t.Errorf("filesPost(%s) status = %v, want %v", tt.target, statusCode, tt.wantCode)
+			}
+			for _, dir := range []string{outside, filepath.Dir(server.dir)} {
+				if _, err := os.Stat(filepath.Join(dir, "escaped.txt")); err == nil {
+					t.Errorf("filesPost(%s) wrote a file outside the root", tt.target)
+				}
+			}
+		})
+	}
+}
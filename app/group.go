@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"strings"
+)
+
+// group registers routes under a common path prefix with their own
+// middleware and not-found handler. Groups can be nested; a route's
+// middleware runs in the order global, outer groups, inner groups, route.
+type group struct {
+	srv        *server
+	parent     *group
+	prefix     string
+	middleware []Middleware
+	notFound   handleFunc
+}
+
+// Group returns a group whose routes are registered under prefix.
+func (s *server) Group(prefix string, mw ...Middleware) *group {
+	g := &group{
+		srv:        s,
+		prefix:     cleanPrefix(prefix),
+		middleware: mw,
+	}
+	s.groups = append(s.groups, g)
+	return g
+}
+
+// Group returns a sub-group of g whose routes are registered under
+// g's prefix followed by prefix.
+func (g *group) Group(prefix string, mw ...Middleware) *group {
+	sub := &group{
+		srv:        g.srv,
+		parent:     g,
+		prefix:     g.prefix + cleanPrefix(prefix),
+		middleware: mw,
+	}
+	g.srv.groups = append(g.srv.groups, sub)
+	return sub
+}
+
+// Use appends middleware for every route of the group and its sub-groups,
+// including routes registered before the call.
+func (g *group) Use(mw ...Middleware) {
+	g.middleware = append(g.middleware, mw...)
+}
+
+// NotFound sets the handler for paths under the group that match no route.
+// Sub-groups without their own handler inherit it.
+func (g *group) NotFound(handler handleFunc) {
+	g.notFound = handler
+}
+
+// Register adds a route for method and the group's prefix followed by
+// pattern.
+func (g *group) Register(method string, pattern string, handler handleFunc, mw ...Middleware) {
+	h := chain(handler, mw...)
+	g.srv.Register(method, g.prefix+pattern, func(ctx context.Context, req *Request, w ResponseWriter) error {
+		return g.wrap(h)(ctx, req, w)
+	})
+}
+
+// wrap applies the middleware of g and its parents to h. It runs per request
+// so that middleware added with Use after Register still applies.
+func (g *group) wrap(h handleFunc) handleFunc {
+	for cur := g; cur != nil; cur = cur.parent {
+		h = chain(h, cur.middleware...)
+	}
+	return h
+}
+
+// handleNotFound answers a request under the group that matched no route,
+// using the closest not-found handler set on g or its parents.
+func (g *group) handleNotFound(ctx context.Context, req *Request, w ResponseWriter) error {
+	h := g.srv.handleNotFound
+	for cur := g; cur != nil; cur = cur.parent {
+		if cur.notFound != nil {
+			h = cur.notFound
+			break
+		}
+	}
+	return g.wrap(h)(ctx, req, w)
+}
+
+// contains reports whether path is the group's prefix or below it.
+func (g *group) contains(path string) bool {
+	rest, ok := strings.CutPrefix(path, g.prefix)
+	return ok && (rest == "" || rest[0] == '/')
+}
+
+// groupFor returns the innermost group containing path, or nil.
+func (s *server) groupFor(path string) *group {
+	var best *group
+	for _, g := range s.groups {
+		if g.contains(path) && (best == nil || len(g.prefix) > len(best.prefix)) {
+			best = g
+		}
+	}
+	return best
+}
+
+// cleanPrefix normalizes a group prefix to start with a slash and not end
+// with one, so that "/" and "" both mean no prefix.
+func cleanPrefix(prefix string) string {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix != "" && !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+	return prefix
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestGroup_Register(t *testing.T) {
+	server := createTestServer(t)
+
+	var got string
+	api := server.Group("/api/v1")
+	api.Register("GET", "/users/{id}", func(ctx context.Context, req *Request, w ResponseWriter) error {
+		got = req.PathValue("id")
+		return httpResponse(w, http.StatusOK, "user")
+	})
+	admin := api.Group("admin/")
+	admin.Register("GET", "/{$}", func(ctx context.Context, req *Request, w ResponseWriter) error {
+		got = "admin"
+		return nil
+	})
+
+	if server.routes[0].pattern != "/api/v1/users/{id}" {
+		t.Errorf("Group.Register() pattern = %q, want /api/v1/users/{id}", server.routes[0].pattern)
+	}
+	if server.routes[1].pattern != "/api/v1/admin/{$}" {
+		t.Errorf("nested Group.Register() pattern = %q, want /api/v1/admin/{$}", server.routes[1].pattern)
+	}
+
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{target: "/api/v1/users/42", want: "42"},
+		{target: "/api/v1/admin", want: "admin"},
+		{target: "/api/v1/admin/", want: "admin"},
+	}
+	for _, tt := range tests {
+		got = ""
+		var buf bytes.Buffer
+		req := createTestRequest("GET", tt.target, "HTTP/1.1", nil, nil)
+		if err := routeRequest(context.Background(), server, req, &buf); err != nil {
+			t.Fatalf("Route(%s) error = %v", tt.target, err)
+		}
+		if got != tt.want {
+			t.Errorf("Route(%s) handler saw %q, want %q", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestGroup_MiddlewareOrder(t *testing.T) {
+	server := createTestServer(t)
+
+	var calls []string
+	server.Use(recordMiddleware(&calls, "global"))
+	api := server.Group("/api", recordMiddleware(&calls, "api"))
+	v1 := api.Group("/v1")
+	v1.Register("GET", "/items", func(ctx context.Context, req *Request, w ResponseWriter) error {
+		calls = append(calls, "handler")
+		return nil
+	}, recordMiddleware(&calls, "route"))
+	// Added after registration, still applies to the route.
+	v1.Use(recordMiddleware(&calls, "v1"))
+
+	// Routes outside the group do not run its middleware.
+	server.Register("GET", "/other", func(ctx context.Context, req *Request, w ResponseWriter) error {
+		calls = append(calls, "other")
+		return nil
+	})
+
+	var buf bytes.Buffer
+	req := createTestRequest("GET", "/api/v1/items", "HTTP/1.1", nil, nil)
+	if err := routeRequest(context.Background(), server, req, &buf); err != nil {
+		t.Fatalf("Route() error = %v", err)
+	}
+	want := "global:before,api:before,v1:before,route:before,handler,route:after,v1:after,api:after,global:after"
+	if strings.Join(calls, ",") != want {
+		t.Errorf("calls = %v, want %s", calls, want)
+	}
+
+	calls = nil
+	req = createTestRequest("GET", "/other", "HTTP/1.1", nil, nil)
+	if err := routeRequest(context.Background(), server, req, &buf); err != nil {
+		t.Fatalf("Route() error = %v", err)
+	}
+	if strings.Join(calls, ",") != "global:before,other,global:after" {
+		t.Errorf("calls = %v, want only global middleware", calls)
+	}
+}
+
+func TestGroup_NotFound(t *testing.T) {
+	server := createTestServer(t)
+
+	api := server.Group("/api")
+	api.NotFound(func(ctx context.Context, req *Request, w ResponseWriter) error {
+		w.Header().Set(HeaderContentType, "application/json")
+		return httpResponse(w, http.StatusNotFound, `{"error":"not found"}`)
+	})
+	api.Register("GET", "/items", func(ctx context.Context, req *Request, w ResponseWriter) error {
+		return nil
+	})
+	api.Group("/v2")
+	internal := server.Group("/internal")
+	internal.Register("GET", "/health", func(ctx context.Context, req *Request, w ResponseWriter) error {
+		return nil
+	})
+
+	tests := []struct {
+		name     string
+		target   string
+		wantBody string
+	}{
+		{name: "Under group", target: "/api/missing", wantBody: `{"error":"not found"}`},
+		{name: "Group root", target: "/api", wantBody: `{"error":"not found"}`},
+		{name: "Inherited by sub-group", target: "/api/v2/missing", wantBody: `{"error":"not found"}`},
+		{name: "Similar prefix outside group", target: "/apix", wantBody: ""},
+		{name: "Group without handler uses default", target: "/internal/missing", wantBody: ""},
+		{name: "Outside any group", target: "/missing", wantBody: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			req := createTestRequest("GET", tt.target, "HTTP/1.1", nil, nil)
+			if err := routeRequest(context.Background(), server, req, &buf); err != nil {
+				t.Fatalf("Route() error = %v", err)
+			}
+			statusCode, _, body := parseHTTPResponse(buf.String())
+			if statusCode != 404 {
+				t.Errorf("Route() status = %d, want 404", statusCode)
+			}
+			if body != tt.wantBody {
+				t.Errorf("Route() body = %q, want %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestCleanPrefix(t *testing.T) {
+	tests := map[string]string{
+		"":         "",
+		"/":        "",
+		"/api":     "/api",
+		"/api/":    "/api",
+		"api":      "/api",
+		"/api/v1/": "/api/v1",
+	}
+	for in, want := range tests {
+		if got := cleanPrefix(in); got != want {
+			t.Errorf("cleanPrefix(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -2,71 +2,147 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"io"
+	"log"
 	"net/http"
-	"os"
-	"path"
-	"strings"
 )
 
-type handleFunc func(context.Context, *Request, io.Writer) error
+type handleFunc func(context.Context, *Request, ResponseWriter) error
 
-func (s *server) rootGet(_ context.Context, req *Request, w io.Writer) error {
-	return httpResponse(w, http.StatusOK, NewResponseHeaders(req.Headers), "")
+func (s *server) rootGet(_ context.Context, req *Request, w ResponseWriter) error {
+	return httpResponse(w, http.StatusOK, "")
 }
 
-func (s *server) handleNotFound(_ context.Context, req *Request, w io.Writer) error {
-	return httpResponse(w, http.StatusNotFound, NewResponseHeaders(req.Headers), "")
+func (s *server) handleNotFound(_ context.Context, req *Request, w ResponseWriter) error {
+	return httpResponse(w, http.StatusNotFound, "")
 }
 
-func (s *server) echoGet(_ context.Context, req *Request, w io.Writer) error {
-	echo := strings.TrimPrefix(req.Target, "/echo/")
-	headers := NewResponseHeaders(req.Headers)
-	headers.Set(HeaderContentType, ContentTypeTextPlain)
+func (s *server) handleMethodNotAllowed(_ context.Context, req *Request, w ResponseWriter) error {
+	return httpResponse(w, http.StatusMethodNotAllowed, "")
+}
+
+// handleOptions answers OPTIONS requests for paths without an OPTIONS
+// handler. The Allow header is set by Route.
+func (s *server) handleOptions(_ context.Context, req *Request, w ResponseWriter) error {
+	return httpResponse(w, http.StatusNoContent, "")
+}
+
+func (s *server) echoGet(_ context.Context, req *Request, w ResponseWriter) error {
+	echo := req.PathValue("text")
+	w.Header().Set(HeaderContentType, ContentTypeTextPlain)
 
 	encoder := encoderFromRequest(req)
 	if encoder != nil {
 		encoded, err := encoder.Encode([]byte(echo))
 		if err != nil {
 			err = fmt.Errorf("failed to encode response: %w", err)
-			return httpResponse(w, http.StatusInternalServerError, headers, err.Error())
+			return httpResponse(w, http.StatusInternalServerError, err.Error())
 		}
-		headers.Set(HeaderContentEncoding, EncodingGzip)
-		return httpResponse(w, http.StatusOK, headers, encoded)
+		w.Header().Set(HeaderContentEncoding, EncodingGzip)
+		return httpResponse(w, http.StatusOK, encoded)
 	}
 
-	return httpResponse(w, http.StatusOK, headers, echo)
+	return httpResponse(w, http.StatusOK, echo)
 }
 
-func (s *server) userAgentGet(_ context.Context, req *Request, w io.Writer) error {
+func (s *server) userAgentGet(_ context.Context, req *Request, w ResponseWriter) error {
 	userAgent, _ := req.Headers.Get(HeaderUserAgent)
-	headers := NewResponseHeaders(req.Headers)
-	headers.Set(HeaderContentType, ContentTypeTextPlain)
-	return httpResponse(w, http.StatusOK, headers, userAgent)
+	w.Header().Set(HeaderContentType, ContentTypeTextPlain)
+	return httpResponse(w, http.StatusOK, userAgent)
 }
 
-func (s *server) filesGet(_ context.Context, req *Request, w io.Writer) error {
-	fileName := strings.TrimPrefix(req.Target, "/files/")
-	b, err := os.ReadFile(path.Join(s.dir, fileName))
-	if os.IsNotExist(err) {
-		return httpResponse(w, http.StatusNotFound, NewResponseHeaders(req.Headers), "")
-	} else if err != nil {
-		headers := NewResponseHeaders(req.Headers)
-		headers.Set(HeaderContentType, ContentTypeTextPlain)
-		return httpResponse(w, http.StatusInternalServerError, headers, err.Error())
+func (s *server) filesGet(_ context.Context, req *Request, w ResponseWriter) error {
+	fileName := req.PathValue("name")
+	f, err := s.files().open(fileName)
+	if err != nil {
+		return fileError(w, err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fileError(w, err)
+	}
+	if info.IsDir() {
+		return s.listDir(w, req, f, fileName)
 	}
-	headers := NewResponseHeaders(req.Headers)
-	headers.Set(HeaderContentType, ContentTypeApplicationOctetStream)
-	return httpResponse(w, http.StatusOK, headers, string(b))
+
+	etag, err := s.fileETag(f, info)
+	if err != nil {
+		return fileError(w, err)
+	}
+
+	// Stream the file rather than reading it into memory so that large
+	// downloads use a constant amount of memory.
+	if err := serveFile(w, req, f, info, etag); err != nil {
+		return fmt.Errorf("failed to send file: %w", err)
+	}
+	return nil
 }
 
-func (s *server) filesPost(_ context.Context, req *Request, w io.Writer) error {
-	fileName := strings.TrimPrefix(req.Target, "/files/")
-	if err := os.WriteFile(path.Join(s.dir, fileName), req.Body, 0o644); err != nil {
-		headers := NewResponseHeaders(req.Headers)
-		headers.Set(HeaderContentType, ContentTypeTextPlain)
-		return httpResponse(w, http.StatusInternalServerError, headers, err.Error())
+func (s *server) filesPost(_ context.Context, req *Request, w ResponseWriter) error {
+	fileName := req.PathValue("name")
+	etag, _, err := s.storeFile(req, fileName)
+	if err != nil {
+		return fileError(w, err)
+	}
+	w.Header().Set(HeaderETag, etag)
+	w.Header().Set(HeaderLocation, fileLocation(fileName))
+	return httpResponse(w, http.StatusCreated, "")
+}
+
+// filesPut stores the request body as the file, answering 201 if it was
+// created and 204 if it replaced an existing file.
+func (s *server) filesPut(_ context.Context, req *Request, w ResponseWriter) error {
+	fileName := req.PathValue("name")
+	etag, created, err := s.storeFile(req, fileName)
+	if err != nil {
+		return fileError(w, err)
+	}
+	w.Header().Set(HeaderETag, etag)
+	if !created {
+		return httpResponse(w, http.StatusNoContent, "")
+	}
+	w.Header().Set(HeaderLocation, fileLocation(fileName))
+	return httpResponse(w, http.StatusCreated, "")
+}
+
+// filesPatch appends the request body to the file.
+func (s *server) filesPatch(_ context.Context, req *Request, w ResponseWriter) error {
+	etag, err := s.appendFile(req, req.PathValue("name"))
+	if err != nil {
+		return fileError(w, err)
+	}
+	w.Header().Set(HeaderETag, etag)
+	return httpResponse(w, http.StatusNoContent, "")
+}
+
+func (s *server) filesDelete(_ context.Context, req *Request, w ResponseWriter) error {
+	if err := s.deleteFile(req, req.PathValue("name")); err != nil {
+		return fileError(w, err)
+	}
+	return httpResponse(w, http.StatusNoContent, "")
+}
+
+// fileError answers a request whose file operation failed. A missing file
+// gets an empty 404; other failures report the error as plain text, a
+// *statusError by its reason alone since the status line already carries
+// its code. Internal errors are logged rather than sent, since they come
+// from the os package and name paths on the server.
+func fileError(w ResponseWriter, err error) error {
+	code := fileErrorStatus(err)
+	if code == http.StatusNotFound {
+		return httpResponse(w, code, "")
+	}
+	reason := err.Error()
+	var se *statusError
+	if errors.As(err, &se) {
+		reason = se.reason
+	} else if code == http.StatusInternalServerError {
+		log.Println("File operation failed: ", err.Error())
+		reason = http.StatusText(code)
 	}
-	return httpResponse(w, http.StatusCreated, NewResponseHeaders(req.Headers), "")
+	w.Header().Set(HeaderContentType, ContentTypeTextPlain)
+	return httpResponse(w, code, reason)
 }
@@ -3,7 +3,6 @@ package main
 import (
 	"bytes"
 	"context"
-	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -24,28 +23,49 @@ func createTestRequest(method, target, version string, headers map[string]string
 		Method:  method,
 		Target:  target,
 		Version: version,
-		Headers: make(Headers),
+		Headers: NewHeaders(),
 		Body:    body,
 	}
 
 	for k, v := range headers {
 		req.Headers.Set(k, v)
 	}
+	// Tests of invalid targets leave Path empty.
+	_ = req.parseTarget()
 
 	return req
 }
 
+// Helper function to capture the path value a route pattern ending in a
+// catch-all would have set, for handlers called without going through Route
+func withPathValue(req *Request, name, prefix string) *Request {
+	req.SetPathValue(name, strings.TrimPrefix(req.Path, prefix))
+	return req
+}
+
 // Helper function to capture response
-func captureResponse(t *testing.T, handler func(context.Context, *Request, io.Writer) error, req *Request) *bytes.Buffer {
+func captureResponse(t *testing.T, handler handleFunc, req *Request) *bytes.Buffer {
 	var buf bytes.Buffer
 	ctx := context.Background()
-	err := handler(ctx, req, &buf)
-	if err != nil {
+	resp := newResponse(&buf, req)
+	if err := handler(ctx, req, resp); err != nil {
 		t.Fatalf("Handler returned error: %v", err)
 	}
+	if err := resp.finish(); err != nil {
+		t.Fatalf("Failed to finish response: %v", err)
+	}
 	return &buf
 }
 
+// Helper function to route a request and capture its response in buf
+func routeRequest(ctx context.Context, s *server, req *Request, buf *bytes.Buffer) error {
+	resp := newResponse(buf, req)
+	if err := s.Route(ctx, req, resp); err != nil {
+		return err
+	}
+	return resp.finish()
+}
+
 // Helper function to parse HTTP response
 func parseHTTPResponse(response string) (statusCode int, headers map[string]string, body string) {
 	lines := strings.Split(response, "\r\n")
@@ -58,6 +78,10 @@ func parseHTTPResponse(response string) (statusCode int, headers map[string]stri
 			statusCode = 200
 		case "201":
 			statusCode = 201
+		case "400":
+			statusCode = 400
+		case "403":
+			statusCode = 403
 		case "404":
 			statusCode = 404
 		case "500":
@@ -243,7 +267,7 @@ func TestEchoGet(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			buf := captureResponse(t, server.echoGet, tt.request)
+			buf := captureResponse(t, server.echoGet, withPathValue(tt.request, "text", "/echo/"))
 			statusCode, headers, body := parseHTTPResponse(buf.String())
 
 			if statusCode != tt.wantCode {
@@ -386,14 +410,14 @@ func TestFilesGet(t *testing.T) {
 		{
 			name:     "Get file with path traversal attempt",
 			request:  createTestRequest("GET", "/files/../etc/passwd", "HTTP/1.1", nil, nil),
-			wantCode: 404,
+			wantCode: 400,
 			wantBody: "",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			buf := captureResponse(t, server.filesGet, tt.request)
+			buf := captureResponse(t, server.filesGet, withPathValue(tt.request, "name", "/files/"))
 			statusCode, headers, body := parseHTTPResponse(buf.String())
 
 			if statusCode != tt.wantCode {
@@ -438,7 +462,7 @@ func TestFilesGet_ReadError(t *testing.T) {
 	}()
 
 	request := createTestRequest("GET", "/files/noread.txt", "HTTP/1.1", nil, nil)
-	buf := captureResponse(t, server.filesGet, request)
+	buf := captureResponse(t, server.filesGet, withPathValue(request, "name", "/files/"))
 	statusCode, headers, _ := parseHTTPResponse(buf.String())
 
 	if statusCode != 500 {
@@ -452,6 +476,29 @@ func TestFilesGet_ReadError(t *testing.T) {
 	}
 }
 
+func TestFilesGet_StreamsLargeFile(t *testing.T) {
+	server := createTestServer(t)
+
+	content := bytes.Repeat([]byte("0123456789abcdef"), 64*1024) // 1 MiB
+	if err := os.WriteFile(filepath.Join(server.dir, "large.bin"), content, 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	request := createTestRequest("GET", "/files/large.bin", "HTTP/1.1", nil, nil)
+	buf := captureResponse(t, server.filesGet, withPathValue(request, "name", "/files/"))
+	resp, body := readTestResponse(t, buf.Bytes(), "GET")
+
+	if resp.StatusCode != 200 {
+		t.Errorf("filesGet() status = %v, want 200", resp.StatusCode)
+	}
+	if resp.ContentLength != int64(len(content)) {
+		t.Errorf("filesGet() Content-Length = %d, want %d", resp.ContentLength, len(content))
+	}
+	if !bytes.Equal(body, content) {
+		t.Errorf("filesGet() body length = %d, want %d", len(body), len(content))
+	}
+}
+
 func TestFilesPost(t *testing.T) {
 	server := createTestServer(t)
 
@@ -494,7 +541,7 @@ func TestFilesPost(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			buf := captureResponse(t, server.filesPost, tt.request)
+			buf := captureResponse(t, server.filesPost, withPathValue(tt.request, "name", "/files/"))
 			statusCode, _, body := parseHTTPResponse(buf.String())
 
 			if statusCode != tt.wantCode {
@@ -540,7 +587,7 @@ func TestFilesPost_WriteError(t *testing.T) {
 	}()
 
 	request := createTestRequest("POST", "/files/readonly/test.txt", "HTTP/1.1", nil, []byte("test"))
-	buf := captureResponse(t, server.filesPost, request)
+	buf := captureResponse(t, server.filesPost, withPathValue(request, "name", "/files/"))
 	statusCode, headers, _ := parseHTTPResponse(buf.String())
 
 	if statusCode != 500 {
@@ -556,7 +603,7 @@ func TestFilesPost_WriteError(t *testing.T) {
 
 // Test helper functions
 func TestNewResponseHeaders(t *testing.T) {
-	reqHeaders := make(Headers)
+	reqHeaders := NewHeaders()
 	reqHeaders.Set("Connection", "keep-alive")
 	reqHeaders.Set("Host", "localhost")
 	reqHeaders.Set("User-Agent", "test")
@@ -589,19 +636,19 @@ func BenchmarkRootGet(b *testing.B) {
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		var buf bytes.Buffer
-		server.rootGet(ctx, request, &buf)
+		server.rootGet(ctx, request, newResponse(&buf, request))
 	}
 }
 
 func BenchmarkEchoGet(b *testing.B) {
 	server := createTestServer(&testing.T{})
-	request := createTestRequest("GET", "/echo/benchmark", "HTTP/1.1", nil, nil)
+	request := withPathValue(createTestRequest("GET", "/echo/benchmark", "HTTP/1.1", nil, nil), "text", "/echo/")
 	ctx := context.Background()
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		var buf bytes.Buffer
-		server.echoGet(ctx, request, &buf)
+		server.echoGet(ctx, request, newResponse(&buf, request))
 	}
 }
 
@@ -615,6 +662,6 @@ func BenchmarkUserAgentGet(b *testing.B) {
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		var buf bytes.Buffer
-		server.userAgentGet(ctx, request, &buf)
+		server.userAgentGet(ctx, request, newResponse(&buf, request))
 	}
 }
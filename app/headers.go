@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"iter"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Headers holds header fields keyed by their canonical name, so lookups are
+// case-insensitive and take constant time. A field may have several values,
+// as when it is repeated on the wire, and fields are kept in the order they
+// were first added so that writing them out is deterministic.
+//
+// Read methods may be called on a nil *Headers.
+type Headers struct {
+	keys   []string // canonical keys in the order first added
+	values map[string][]string
+}
+
+func NewHeaders() *Headers {
+	return &Headers{values: make(map[string][]string)}
+}
+
+func NewResponseHeaders(reqHeaders *Headers) *Headers {
+	copyHeaders := []string{
+		HeaderConnection,
+	}
+
+	h := NewHeaders()
+	for _, cp := range copyHeaders {
+		for _, v := range reqHeaders.Values(cp) {
+			h.Add(cp, v)
+		}
+	}
+
+	return h
+}
+
+func canonicalKey(k string) string {
+	return http.CanonicalHeaderKey(strings.TrimSpace(k))
+}
+
+// Get returns the first value of the given key if found.
+// The given key is case-insensitive.
+func (h *Headers) Get(k string) (string, bool) {
+	vs := h.Values(k)
+	if len(vs) == 0 {
+		return "", false
+	}
+	return vs[0], true
+}
+
+// Values returns every value of the given key, in the order they were added.
+// The returned slice must not be modified.
+func (h *Headers) Values(k string) []string {
+	if h == nil {
+		return nil
+	}
+	return h.values[canonicalKey(k)]
+}
+
+// Set replaces any values of k with v. An empty value removes k.
+func (h *Headers) Set(k, v string) {
+	k = canonicalKey(k)
+	if k == "" {
+		return
+	} else if v == "" {
+		h.Del(k)
+		return
+	}
+
+	if _, ok := h.values[k]; !ok {
+		h.keys = append(h.keys, k)
+	}
+	h.init()
+	h.values[k] = []string{v}
+}
+
+// Add appends v to the values of k, keeping any existing ones.
+func (h *Headers) Add(k, v string) {
+	k = canonicalKey(k)
+	if k == "" {
+		return
+	}
+
+	h.init()
+	if _, ok := h.values[k]; !ok {
+		h.keys = append(h.keys, k)
+	}
+	h.values[k] = append(h.values[k], v)
+}
+
+// Del removes all values of k.
+func (h *Headers) Del(k string) {
+	k = canonicalKey(k)
+	if _, ok := h.values[k]; !ok {
+		return
+	}
+	delete(h.values, k)
+	h.keys = slices.DeleteFunc(h.keys, func(key string) bool { return key == k })
+}
+
+// Len returns the number of distinct keys.
+func (h *Headers) Len() int {
+	if h == nil {
+		return 0
+	}
+	return len(h.keys)
+}
+
+// All yields every key and value pair in order. A key with several values
+// is yielded once per value.
+func (h *Headers) All() iter.Seq2[string, string] {
+	return func(yield func(string, string) bool) {
+		if h == nil {
+			return
+		}
+		for _, k := range h.keys {
+			for _, v := range h.values[k] {
+				if !yield(k, v) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Clone returns a copy of h that shares no state with it.
+func (h *Headers) Clone() *Headers {
+	c := NewHeaders()
+	if h == nil {
+		return c
+	}
+	c.keys = slices.Clone(h.keys)
+	for k, vs := range h.values {
+		c.values[k] = slices.Clone(vs)
+	}
+	return c
+}
+
+// Write writes the fields in wire format, one "Key: value" line per value.
+func (h *Headers) Write(w io.Writer) error {
+	var sb strings.Builder
+	for k, v := range h.All() {
+		sb.WriteString(k)
+		sb.WriteString(": ")
+		sb.WriteString(v)
+		sb.WriteString("\r\n")
+	}
+	if _, err := io.WriteString(w, sb.String()); err != nil {
+		return fmt.Errorf("failed to write headers: %w", err)
+	}
+	return nil
+}
+
+// hasToken reports whether the comma-separated lists in the values of
+// header k contain token, compared case-insensitively.
+func (h *Headers) hasToken(k, token string) bool {
+	for _, v := range h.Values(k) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// joined returns the values of header k as a single comma-separated list,
+// the form a list-valued field split across several lines is equivalent to.
+func (h *Headers) joined(k string) (string, bool) {
+	vs := h.Values(k)
+	return strings.Join(vs, ", "), len(vs) > 0
+}
+
+// extendLast appends s to the last value of k, if it has one.
+func (h *Headers) extendLast(k, s string) {
+	vs := h.Values(k)
+	if len(vs) > 0 {
+		vs[len(vs)-1] += s
+	}
+}
+
+func (h *Headers) init() {
+	if h.values == nil {
+		h.values = make(map[string][]string)
+	}
+}
@@ -1,158 +1,208 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
-	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 )
 
 const (
-	HeaderAcceptEncoding  = "Accept-Encoding"
-	HeaderContentLength   = "Content-Length"
-	HeaderContentType     = "Content-Type"
-	HeaderContentEncoding = "Content-Encoding"
-	HeaderUserAgent       = "User-Agent"
-	HeaderConnection      = "Connection"
+	HeaderAccept            = "Accept"
+	HeaderAcceptEncoding    = "Accept-Encoding"
+	HeaderContentLength     = "Content-Length"
+	HeaderContentType       = "Content-Type"
+	HeaderContentEncoding   = "Content-Encoding"
+	HeaderUserAgent         = "User-Agent"
+	HeaderReferer           = "Referer"
+	HeaderConnection        = "Connection"
+	HeaderTransferEncoding  = "Transfer-Encoding"
+	HeaderTrailer           = "Trailer"
+	HeaderHost              = "Host"
+	HeaderAllow             = "Allow"
+	HeaderExpect            = "Expect"
+	HeaderRange             = "Range"
+	HeaderIfRange           = "If-Range"
+	HeaderContentRange      = "Content-Range"
+	HeaderAcceptRanges      = "Accept-Ranges"
+	HeaderLastModified      = "Last-Modified"
+	HeaderETag              = "ETag"
+	HeaderIfMatch           = "If-Match"
+	HeaderIfNoneMatch       = "If-None-Match"
+	HeaderIfModifiedSince   = "If-Modified-Since"
+	HeaderIfUnmodifiedSince = "If-Unmodified-Since"
+	HeaderLocation          = "Location"
+	HeaderVary              = "Vary"
 
 	ContentTypeTextPlain              = "text/plain"
 	ContentTypeApplicationOctetStream = "application/octet-stream"
+	ContentTypeApplicationJSON        = "application/json"
+	ContentTypeTextHTML               = "text/html; charset=utf-8"
 
 	ConnectionKeepAlive = "keep-alive"
 	ConnectionClose     = "close"
+
+	TransferEncodingChunked = "chunked"
+
+	ExpectContinue = "100-continue"
+
+	RangeUnitBytes = "bytes"
 )
 
 type Request struct {
-	Method  string
-	Target  string
-	Version string
-	Headers Headers
-	Body    []byte
+	Method string
+	// Target is the request-target exactly as sent by the client.
+	Target string
+	// Path is the percent-decoded path of Target, used for routing.
+	Path string
+	// RawQuery is the query of Target without the '?', still encoded.
+	RawQuery string
+	Version  string
+	Headers  *Headers
+	Body     []byte
+
+	// Trailers holds the trailer fields sent after a chunked body.
+	Trailers *Headers
+
+	// Pattern is the route pattern that matched the request, set by Route.
+	Pattern string
+
+	// pathValues holds the parameters captured by the matched route.
+	pathValues map[string]string
+
+	// expectContinue is set when the client sent Expect: 100-continue and
+	// waits for an interim response before sending the body, which body
+	// then reads on demand.
+	expectContinue bool
+	body           *bodyReader
 }
 
-type Headers map[string]string
-
-func NewResponseHeaders(reqHeaders Headers) Headers {
-	copyHeaders := []string{
-		HeaderConnection,
+// parseTarget sets Path and RawQuery from Target. Origin-form ("/a?b"),
+// absolute-form ("http://host/a?b") and asterisk-form ("*") targets are
+// accepted; invalid percent-escapes in the path or query are an error.
+func (r *Request) parseTarget() error {
+	target := r.Target
+	if target == "*" {
+		r.Path, r.RawQuery = target, ""
+		return nil
+	}
+	if i := strings.Index(target, "://"); i > 0 && !strings.HasPrefix(target, "/") {
+		// Absolute-form: drop the scheme and authority.
+		rest := target[i+len("://"):]
+		if j := strings.IndexAny(rest, "/?"); j >= 0 {
+			target = rest[j:]
+		} else {
+			target = "/"
+		}
 	}
 
-	h := make(Headers)
-	for _, cp := range copyHeaders {
-		v, _ := reqHeaders.Get(cp)
-		h.Set(cp, v)
+	rawPath, rawQuery, _ := strings.Cut(target, "?")
+	if !strings.HasPrefix(rawPath, "/") {
+		if rawPath != "" {
+			return fmt.Errorf("invalid request target %q", r.Target)
+		}
+		rawPath = "/"
+	}
+	p, err := url.PathUnescape(rawPath)
+	if err != nil {
+		return fmt.Errorf("invalid request target %q: %w", r.Target, err)
+	}
+	if _, err := url.QueryUnescape(rawQuery); err != nil {
+		return fmt.Errorf("invalid request query %q: %w", rawQuery, err)
 	}
 
-	return h
+	r.Path, r.RawQuery = p, rawQuery
+	return nil
 }
 
-// Get retrieves the value of the given key if found.
-// The given key is case-insensitive.
-func (h Headers) Get(k string) (string, bool) {
-	for hk, v := range h {
-		if strings.EqualFold(hk, k) {
-			return v, true
-		}
-	}
-	return "", false
+// Query parses RawQuery. A key sent several times has all of its values,
+// in order.
+func (r *Request) Query() url.Values {
+	v, _ := url.ParseQuery(r.RawQuery)
+	return v
 }
 
-func (h Headers) Set(k, v string) {
-	if k == "" {
-		return
-	} else if v == "" {
-		delete(h, k)
-		return
+// PathValue returns the value of the named parameter captured by the route
+// pattern that matched the request, or "" if there is none.
+func (r *Request) PathValue(name string) string {
+	return r.pathValues[name]
+}
+
+// SetPathValue sets the named path parameter, as if it had been captured by
+// the matched route.
+func (r *Request) SetPathValue(name, value string) {
+	if r.pathValues == nil {
+		r.pathValues = make(map[string]string)
 	}
+	r.pathValues[name] = value
+}
 
-	k = strings.TrimSpace(k)
-	for hk := range h {
-		if strings.EqualFold(hk, k) {
-			h[hk] = v
-			return
-		}
+// wantsKeepAlive reports whether the client asked for the connection to be
+// kept open after this request.
+func (r *Request) wantsKeepAlive() bool {
+	connectionHeader, _ := r.Headers.Get(HeaderConnection)
+	if r.Version == "HTTP/1.1" {
+		// HTTP/1.1 defaults to keep-alive unless explicitly closed
+		return !strings.EqualFold(connectionHeader, ConnectionClose)
 	}
-	h[http.CanonicalHeaderKey(k)] = v
+	// HTTP/1.0 defaults to close unless explicitly keep-alive
+	return strings.EqualFold(connectionHeader, ConnectionKeepAlive)
 }
 
+// From parses a complete request from b. Bytes beyond the request's body
+// are ignored.
 func (r *Request) From(b []byte) error {
-	s := string(b)
-	parts := strings.Split(s, "\r\n")
-
-	// Meta
-	if len(parts) == 0 || parts[0] == "" {
+	req, err := readRequest(bufio.NewReader(bytes.NewReader(b)), requestLimits{
+		maxHeaderBytes: defaultMaxHeaderBytes,
+		maxBodyBytes:   defaultMaxBodyBytes,
+	})
+	if errors.Is(err, io.EOF) {
 		return fmt.Errorf("invalid request: missing request line")
+	} else if err != nil {
+		return err
 	}
-
-	metaSegs := strings.Split(parts[0], " ")
-	if len(metaSegs) < 3 {
-		return fmt.Errorf("invalid request line: expected 3 parts, got %d", len(metaSegs))
-	}
-
-	r.Method = strings.TrimSpace(metaSegs[0])
-	r.Target = strings.TrimSpace(metaSegs[1])
-	r.Version = strings.TrimSpace(metaSegs[2])
-
-	// Headers
-	if r.Headers == nil {
-		r.Headers = make(map[string]string)
-	}
-	for _, l := range parts[1 : len(parts)-1] {
-		if len(l) == 0 {
-			continue
+	if req.body != nil {
+		// There is no one to send 100 Continue to; the body is in b.
+		if req.Body, err = io.ReadAll(req.body); err != nil {
+			return err
 		}
-		kv := strings.SplitN(l, ":", 2)
-		if len(kv) < 2 {
-			// Skip malformed header lines
-			continue
-		}
-		k, v := kv[0], kv[1]
-		r.Headers.Set(strings.TrimSpace(k), strings.TrimSpace(v))
+		req.body, req.expectContinue = nil, false
 	}
-
-	// Body
-	r.Body = []byte(parts[len(parts)-1])
-
+	*r = *req
 	return nil
 }
 
 func (r *Request) String() string {
-	s := fmt.Sprintf("%s\n%s\n%s\n\r\n", r.Method, r.Target, r.Version)
-	for k, v := range r.Headers {
-		s += fmt.Sprintf("%s:	%s\r\n", k, v)
-	}
-	s += fmt.Sprintf("\r\n%s", r.Body)
-	return s
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s\n%s\n%s\n\r\n", r.Method, r.Target, r.Version)
+	r.Headers.Write(&sb)
+	fmt.Fprintf(&sb, "\r\n%s", r.Body)
+	return sb.String()
 }
 
-func httpResponse(w io.Writer, code int, headers Headers, body any) error {
-	s := fmt.Sprintf(
-		"HTTP/1.1 %d %s\r\n",
-		code,
-		http.StatusText(code),
-	)
-
-	// Ensure we have a headers map
-	if headers == nil {
-		headers = make(Headers)
-	}
-
-	// Set Connection header to keep-alive by default if not already set
-	// This allows clients to see that the server supports persistent connections
-	if _, hasConnection := headers.Get(HeaderConnection); !hasConnection {
-		headers.Set(HeaderConnection, ConnectionKeepAlive)
-	}
-
-	for h, v := range headers {
-		s += fmt.Sprintf("%s: %s\r\n", h, v)
+// httpResponse writes a complete response with the given status and body.
+// Headers should be set on w beforehand.
+func httpResponse(w ResponseWriter, code int, body any) error {
+	var b []byte
+	switch v := body.(type) {
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		b = []byte(fmt.Sprint(v))
 	}
 
-	bodyLength := len(fmt.Sprintf("%s", body))
-	if bodyLength > 0 {
-		s += fmt.Sprintf("%s: %d\r\n\r\n%s", HeaderContentLength, bodyLength, body)
+	if bodyAllowed(code) {
+		w.Header().Set(HeaderContentLength, strconv.Itoa(len(b)))
 	}
+	w.WriteHeader(code)
 
-	if _, err := w.Write([]byte(s)); err != nil {
+	if _, err := w.Write(b); err != nil {
 		return fmt.Errorf("failed to write response: %w", err)
 	}
 
@@ -2,15 +2,19 @@ package main
 
 import (
 	"bytes"
+	"net/url"
+	"reflect"
 	"strings"
 	"testing"
 )
 
 func TestHeaders_Get(t *testing.T) {
-	headers := make(Headers)
-	headers["Content-Type"] = "text/plain"
-	headers["content-length"] = "123"
-	headers["Connection"] = "keep-alive"
+	headers := NewHeaders()
+	headers.Add("Content-Type", "text/plain")
+	headers.Add("content-length", "123")
+	headers.Add("Connection", "keep-alive")
+	headers.Add("Accept", "text/html")
+	headers.Add("accept", "text/plain")
 
 	tests := []struct {
 		name      string
@@ -42,6 +46,12 @@ func TestHeaders_Get(t *testing.T) {
 			wantValue: "123",
 			wantFound: true,
 		},
+		{
+			name:      "Repeated header returns the first value",
+			key:       "ACCEPT",
+			wantValue: "text/html",
+			wantFound: true,
+		},
 		{
 			name:      "Non-existent header",
 			key:       "Authorization",
@@ -115,10 +125,10 @@ func TestHeaders_Set(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			headers := make(Headers)
+			headers := NewHeaders()
 
 			if tt.initialKey != "" {
-				headers[tt.initialKey] = tt.initialValue
+				headers.Add(tt.initialKey, tt.initialValue)
 			}
 
 			headers.Set(tt.setKey, tt.setValue)
@@ -134,7 +144,7 @@ func TestHeaders_Set(t *testing.T) {
 
 			// Check that only one entry exists for the header
 			count := 0
-			for key := range headers {
+			for key := range headers.All() {
 				if strings.EqualFold(key, tt.wantKey) {
 					count++
 				}
@@ -255,12 +265,128 @@ func TestRequest_From(t *testing.T) {
 	}
 }
 
+func TestHeaders_MultipleValues(t *testing.T) {
+	headers := NewHeaders()
+	headers.Add("Set-Cookie", "a=1")
+	headers.Add("Via", "1.1 proxy")
+	headers.Add("set-cookie", "b=2")
+	headers.Add("X-Empty", "")
+
+	if got := headers.Values("SET-COOKIE"); !reflect.DeepEqual(got, []string{"a=1", "b=2"}) {
+		t.Errorf("Values(Set-Cookie) = %q, want [a=1 b=2]", got)
+	}
+	if v, ok := headers.Get("X-Empty"); !ok || v != "" {
+		t.Errorf("Get(X-Empty) = %q, %v, want empty value found", v, ok)
+	}
+	if headers.Len() != 3 {
+		t.Errorf("Len() = %d, want 3", headers.Len())
+	}
+
+	clone := headers.Clone()
+	clone.Add("Set-Cookie", "c=3")
+	clone.Del("via")
+	if len(headers.Values("Set-Cookie")) != 2 {
+		t.Errorf("adding to a clone changed the original: %q", headers.Values("Set-Cookie"))
+	}
+	if _, ok := headers.Get("Via"); !ok {
+		t.Error("deleting from a clone changed the original")
+	}
+
+	headers.Set("Set-Cookie", "z=9")
+	if got := headers.Values("Set-Cookie"); !reflect.DeepEqual(got, []string{"z=9"}) {
+		t.Errorf("Values(Set-Cookie) after Set = %q, want [z=9]", got)
+	}
+
+	var buf bytes.Buffer
+	if err := headers.Write(&buf); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	// Set keeps a key's original position.
+	want := "Set-Cookie: z=9\r\nVia: 1.1 proxy\r\nX-Empty: \r\n"
+	if buf.String() != want {
+		t.Errorf("Write() = %q, want %q", buf.String(), want)
+	}
+
+	headers.Del("Via")
+	buf.Reset()
+	headers.Write(&buf)
+	if want := "Set-Cookie: z=9\r\nX-Empty: \r\n"; buf.String() != want {
+		t.Errorf("Write() after Del = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestHeaders_NilIsEmpty(t *testing.T) {
+	var headers *Headers
+	if _, ok := headers.Get("Host"); ok {
+		t.Error("Get() on nil Headers found a value")
+	}
+	if headers.Len() != 0 || len(headers.Values("Host")) != 0 {
+		t.Error("nil Headers is not empty")
+	}
+	for k, v := range headers.All() {
+		t.Errorf("All() on nil Headers yielded %s: %s", k, v)
+	}
+}
+
+func TestRequest_ParseTarget(t *testing.T) {
+	tests := []struct {
+		name         string
+		target       string
+		wantPath     string
+		wantRawQuery string
+		wantQuery    url.Values
+	}{
+		{name: "Plain path", target: "/files/a.txt", wantPath: "/files/a.txt"},
+		{name: "Encoded space", target: "/echo/hello%20world", wantPath: "/echo/hello world"},
+		{name: "Plus is not a space in the path", target: "/echo/a+b", wantPath: "/echo/a+b"},
+		{
+			name:         "Query string",
+			target:       "/files/a.txt?download=1",
+			wantPath:     "/files/a.txt",
+			wantRawQuery: "download=1",
+			wantQuery:    url.Values{"download": {"1"}},
+		},
+		{
+			name:         "Repeated and encoded query values",
+			target:       "/search?q=a+b&q=c%26d&empty=",
+			wantPath:     "/search",
+			wantRawQuery: "q=a+b&q=c%26d&empty=",
+			wantQuery:    url.Values{"q": {"a b", "c&d"}, "empty": {""}},
+		},
+		{name: "Absolute form", target: "http://example.com/echo/x?y=1", wantPath: "/echo/x", wantRawQuery: "y=1", wantQuery: url.Values{"y": {"1"}}},
+		{name: "Absolute form without path", target: "http://example.com", wantPath: "/"},
+		{name: "Asterisk form", target: "*", wantPath: "*"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &Request{Target: tt.target}
+			if err := req.parseTarget(); err != nil {
+				t.Fatalf("parseTarget() error = %v", err)
+			}
+			if req.Path != tt.wantPath {
+				t.Errorf("Path = %q, want %q", req.Path, tt.wantPath)
+			}
+			if req.RawQuery != tt.wantRawQuery {
+				t.Errorf("RawQuery = %q, want %q", req.RawQuery, tt.wantRawQuery)
+			}
+			if want := tt.wantQuery; want == nil {
+				if q := req.Query(); len(q) != 0 {
+					t.Errorf("Query() = %v, want empty", q)
+				}
+			} else if !reflect.DeepEqual(req.Query(), want) {
+				t.Errorf("Query() = %v, want %v", req.Query(), want)
+			}
+		})
+	}
+}
+
 func TestRequest_String(t *testing.T) {
 	req := &Request{
 		Method:  "GET",
 		Target:  "/test",
 		Version: "HTTP/1.1",
-		Headers: make(Headers),
+		Headers: NewHeaders(),
 		Body:    []byte("test body"),
 	}
 	req.Headers.Set("Content-Type", "text/plain")
@@ -289,7 +415,7 @@ func TestHttpResponse(t *testing.T) {
 	tests := []struct {
 		name         string
 		code         int
-		headers      Headers
+		headers      *Headers
 		body         interface{}
 		wantContains []string
 		wantStatus   string
@@ -319,8 +445,8 @@ func TestHttpResponse(t *testing.T) {
 		{
 			name: "Response with custom headers",
 			code: 200,
-			headers: func() Headers {
-				h := make(Headers)
+			headers: func() *Headers {
+				h := NewHeaders()
 				h.Set("Content-Type", "application/json")
 				h.Set("Cache-Control", "no-cache")
 				return h
@@ -337,8 +463,8 @@ func TestHttpResponse(t *testing.T) {
 		{
 			name: "Response with connection close",
 			code: 200,
-			headers: func() Headers {
-				h := make(Headers)
+			headers: func() *Headers {
+				h := NewHeaders()
 				h.Set("Connection", "close")
 				return h
 			}(),
@@ -365,7 +491,14 @@ func TestHttpResponse(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			var buf bytes.Buffer
-			err := httpResponse(&buf, tt.code, tt.headers, tt.body)
+			resp := newResponse(&buf, nil)
+			for k, v := range tt.headers.All() {
+				resp.Header().Add(k, v)
+			}
+			err := httpResponse(resp, tt.code, tt.body)
+			if err == nil {
+				err = resp.finish()
+			}
 			if err != nil {
 				t.Errorf("httpResponse() error = %v", err)
 				return
@@ -393,11 +526,37 @@ func TestHttpResponse(t *testing.T) {
 	}
 }
 
+func TestHttpResponse_ByteStable(t *testing.T) {
+	var buf bytes.Buffer
+	resp := newResponse(&buf, nil)
+	resp.Header().Set("Content-Type", "text/plain")
+	resp.Header().Add("Set-Cookie", "a=1")
+	resp.Header().Add("Set-Cookie", "b=2")
+	if err := httpResponse(resp, 200, "ok"); err != nil {
+		t.Fatalf("httpResponse() error = %v", err)
+	}
+	if err := resp.finish(); err != nil {
+		t.Fatalf("finish() error = %v", err)
+	}
+
+	want := "HTTP/1.1 200 OK\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"Set-Cookie: a=1\r\n" +
+		"Set-Cookie: b=2\r\n" +
+		"Content-Length: 2\r\n" +
+		"Connection: keep-alive\r\n" +
+		"\r\n" +
+		"ok"
+	if buf.String() != want {
+		t.Errorf("httpResponse() = %q, want %q", buf.String(), want)
+	}
+}
+
 func BenchmarkHeaders_Get(b *testing.B) {
-	headers := make(Headers)
-	headers["Content-Type"] = "text/plain"
-	headers["Content-Length"] = "100"
-	headers["Connection"] = "keep-alive"
+	headers := NewHeaders()
+	headers.Set("Content-Type", "text/plain")
+	headers.Set("Content-Length", "100")
+	headers.Set("Connection", "keep-alive")
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -406,7 +565,7 @@ func BenchmarkHeaders_Get(b *testing.B) {
 }
 
 func BenchmarkHeaders_Set(b *testing.B) {
-	headers := make(Headers)
+	headers := NewHeaders()
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -425,12 +584,12 @@ func BenchmarkRequest_From(b *testing.B) {
 }
 
 func BenchmarkHttpResponse(b *testing.B) {
-	headers := make(Headers)
-	headers.Set("Content-Type", "text/plain")
-
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		var buf bytes.Buffer
-		httpResponse(&buf, 200, headers, "Hello, World!")
+		resp := newResponse(&buf, nil)
+		resp.Header().Set("Content-Type", "text/plain")
+		httpResponse(resp, 200, "Hello, World!")
+		resp.finish()
 	}
 }
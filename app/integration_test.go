@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"context"
 	"errors"
-	"io"
 	"strings"
 	"testing"
 )
@@ -51,7 +50,11 @@ func TestEchoGet_EncodingError(t *testing.T) {
 	var buf bytes.Buffer
 	ctx := context.Background()
 
-	err := server.echoGet(ctx, request, &buf)
+	resp := newResponse(&buf, request)
+	err := server.echoGet(ctx, withPathValue(request, "text", "/echo/"), resp)
+	if err == nil {
+		err = resp.finish()
+	}
 	if err != nil {
 		t.Errorf("echoGet() unexpected error with large input: %v", err)
 	}
@@ -67,17 +70,21 @@ func TestHttpResponse_ErrorCases(t *testing.T) {
 	// Test with a writer that always fails
 	failingWriter := &failingWriter{}
 
-	err := httpResponse(failingWriter, 200, nil, "test")
+	resp := newResponse(failingWriter, nil)
+	err := httpResponse(resp, 200, "test")
+	if err == nil {
+		err = resp.finish()
+	}
 	if err == nil {
 		t.Error("httpResponse() should return error when writer fails")
 	}
 
 	// Test with failing writer on body write
 	partialWriter := &partialFailingWriter{failOnSecondWrite: true}
-	headers := make(Headers)
-	headers.Set("Content-Type", "text/plain")
+	resp = newResponse(partialWriter, nil)
+	resp.Header().Set("Content-Type", "text/plain")
 
-	err = httpResponse(partialWriter, 200, headers, "test body")
+	err = httpResponse(resp, 200, "test body")
 	// Note: The httpResponse function may not properly handle body write failures
 	// This is a limitation of the current implementation
 	_ = err // Ignore for now as implementation may vary
@@ -85,7 +92,7 @@ func TestHttpResponse_ErrorCases(t *testing.T) {
 
 // Test header edge cases
 func TestHeaders_EdgeCases(t *testing.T) {
-	headers := make(Headers)
+	headers := NewHeaders()
 
 	// Test setting header with empty value
 	headers.Set("Empty-Header", "")
@@ -101,7 +108,7 @@ func TestHeaders_EdgeCases(t *testing.T) {
 
 	// Should only have one header with the latest value
 	count := 0
-	for key := range headers {
+	for key := range headers.All() {
 		if strings.EqualFold(key, "test-header") {
 			count++
 		}
@@ -127,7 +134,7 @@ func TestServer_Route_AdvancedCases(t *testing.T) {
 
 	// This would normally cause issues, but we'll use a valid writer
 	var buf bytes.Buffer
-	err := server.Route(ctx, req, &buf)
+	err := routeRequest(ctx, server, req, &buf)
 	if err != nil {
 		t.Errorf("Route() error: %v", err)
 	}
@@ -167,8 +174,8 @@ func TestRequest_From_EdgeCases(t *testing.T) {
 		{
 			name:        "Request with header but no colon",
 			input:       "GET / HTTP/1.1\r\nInvalidHeader\r\n\r\n",
-			wantError:   false,
-			description: "Malformed header line (should be skipped gracefully)",
+			wantError:   true,
+			description: "Malformed header line (rejected unless lenient)",
 		},
 	}
 
@@ -214,8 +221,8 @@ func TestServer_Route_WithLargeRequest(t *testing.T) {
 	server := createTestServer(t)
 
 	// Register a simple handler
-	server.Register("POST", "/large", func(ctx context.Context, req *Request, w io.Writer) error {
-		return httpResponse(w, 200, nil, "processed")
+	server.Register("POST", "/large", func(ctx context.Context, req *Request, w ResponseWriter) error {
+		return httpResponse(w, 200, "processed")
 	})
 
 	// Create a large request body
@@ -232,7 +239,7 @@ func TestServer_Route_WithLargeRequest(t *testing.T) {
 	var buf bytes.Buffer
 	ctx := context.Background()
 
-	err := server.Route(ctx, req, &buf)
+	err := routeRequest(ctx, server, req, &buf)
 	if err != nil {
 		t.Errorf("Route() with large request error: %v", err)
 	}
@@ -267,14 +274,17 @@ func BenchmarkEncodingLargeData(b *testing.B) {
 }
 
 func BenchmarkHttpResponseLarge(b *testing.B) {
-	headers := make(Headers)
-	headers.Set("Content-Type", "text/plain")
 	largeBody := strings.Repeat("Hello, World! ", 1000)
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		var buf bytes.Buffer
-		err := httpResponse(&buf, 200, headers, largeBody)
+		resp := newResponse(&buf, nil)
+		resp.Header().Set("Content-Type", "text/plain")
+		err := httpResponse(resp, 200, largeBody)
+		if err == nil {
+			err = resp.finish()
+		}
 		if err != nil {
 			b.Fatal(err)
 		}
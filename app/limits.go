@@ -0,0 +1,153 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+// LimitStats counts how often the connection limits were hit.
+type LimitStats struct {
+	// RejectedConns counts connections answered with 503 because maxConns
+	// connections were already open.
+	RejectedConns int64
+	// RejectedPerIP counts connections answered with 503 because their
+	// remote IP already had maxConnsPerIP connections open.
+	RejectedPerIP int64
+	// QueuedConns counts accepts delayed until a connection slot was free.
+	QueuedConns int64
+	// MaxRequestsReached counts connections closed after serving
+	// maxRequestsPerConn requests.
+	MaxRequestsReached int64
+}
+
+type limitCounters struct {
+	rejectedConns      atomic.Int64
+	rejectedPerIP      atomic.Int64
+	queuedConns        atomic.Int64
+	maxRequestsReached atomic.Int64
+}
+
+// LimitStats returns the current limit counters.
+func (s *server) LimitStats() LimitStats {
+	return LimitStats{
+		RejectedConns:      s.limitCounters.rejectedConns.Load(),
+		RejectedPerIP:      s.limitCounters.rejectedPerIP.Load(),
+		QueuedConns:        s.limitCounters.queuedConns.Load(),
+		MaxRequestsReached: s.limitCounters.maxRequestsReached.Load(),
+	}
+}
+
+// connSlots returns the semaphore bounding open connections, or nil if
+// there is no limit.
+func (s *server) connSlots() chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.maxConns > 0 && s.connSlotsCh == nil {
+		s.connSlotsCh = make(chan struct{}, s.maxConns)
+	}
+	return s.connSlotsCh
+}
+
+// acquireConnSlot takes one of the maxConns connection slots. With wait it
+// blocks until a slot is free, reporting false if the server shuts down
+// first; otherwise it reports false at once when every slot is taken.
+func (s *server) acquireConnSlot(wait bool) bool {
+	slots := s.connSlots()
+	if slots == nil {
+		return true
+	}
+	select {
+	case slots <- struct{}{}:
+		return true
+	default:
+	}
+	if !wait {
+		return false
+	}
+
+	s.limitCounters.queuedConns.Add(1)
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-s.closing():
+		return false
+	}
+}
+
+func (s *server) releaseConnSlot() {
+	if slots := s.connSlots(); slots != nil {
+		<-slots
+	}
+}
+
+// acquireIP counts a connection from ip, reporting false if ip already has
+// maxConnsPerIP connections open.
+func (s *server) acquireIP(ip string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.maxConnsPerIP > 0 && s.ipConns[ip] >= s.maxConnsPerIP {
+		return false
+	}
+	if s.ipConns == nil {
+		s.ipConns = make(map[string]int)
+	}
+	s.ipConns[ip]++
+	return true
+}
+
+func (s *server) releaseIP(ip string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ipConns[ip]--; s.ipConns[ip] <= 0 {
+		delete(s.ipConns, ip)
+	}
+}
+
+// admitConn checks a newly accepted connection against the connection
+// limits and returns a function releasing its place once it is closed. When
+// connections are queued, the caller already holds a connection slot, which
+// admitConn takes over.
+func (s *server) admitConn(conn net.Conn) (release func(), rejected *statusError) {
+	if !s.queueConns && !s.acquireConnSlot(false) {
+		s.limitCounters.rejectedConns.Add(1)
+		return nil, newStatusError(http.StatusServiceUnavailable, "too many connections")
+	}
+
+	ip := remoteIP(conn)
+	if !s.acquireIP(ip) {
+		s.releaseConnSlot()
+		s.limitCounters.rejectedPerIP.Add(1)
+		return nil, newStatusError(http.StatusServiceUnavailable, "too many connections from %s", ip)
+	}
+
+	return func() {
+		s.releaseIP(ip)
+		s.releaseConnSlot()
+	}, nil
+}
+
+// rejectConn answers a connection refused by admitConn and closes it.
+func (s *server) rejectConn(conn net.Conn, se *statusError) error {
+	defer conn.Close()
+
+	log.Println("Rejecting connection: ", se.Error())
+	resp := newResponse(deadlineWriter{conn: conn, timeout: s.timeouts().write}, nil)
+	resp.Header().Set(HeaderConnection, ConnectionClose)
+	resp.Header().Set(HeaderContentType, ContentTypeTextPlain)
+	if err := httpResponse(resp, se.code, se.reason); err != nil {
+		return err
+	}
+	return resp.finish()
+}
+
+// remoteIP returns the IP address of the connection's peer, used to key the
+// per-IP connection limit.
+func remoteIP(conn net.Conn) string {
+	addr := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestAdmitConn(t *testing.T) {
+	tests := []struct {
+		name       string
+		server     *server
+		conns      int
+		wantAdmit  int
+		wantStats  LimitStats
+		wantReason string
+	}{
+		{
+			name:      "No limits",
+			server:    &server{},
+			conns:     5,
+			wantAdmit: 5,
+		},
+		{
+			name:       "Global limit",
+			server:     &server{maxConns: 2},
+			conns:      3,
+			wantAdmit:  2,
+			wantStats:  LimitStats{RejectedConns: 1},
+			wantReason: "too many connections",
+		},
+		{
+			name:       "Per-IP limit",
+			server:     &server{maxConns: 10, maxConnsPerIP: 1},
+			conns:      3,
+			wantAdmit:  1,
+			wantStats:  LimitStats{RejectedPerIP: 2},
+			wantReason: "too many connections from pipe",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var releases []func()
+			var lastRejected *statusError
+			for range tt.conns {
+				// Pipe connections all share the remote address "pipe".
+				c1, c2 := net.Pipe()
+				defer c1.Close()
+				defer c2.Close()
+				release, rejected := tt.server.admitConn(c2)
+				if rejected != nil {
+					lastRejected = rejected
+					continue
+				}
+				releases = append(releases, release)
+			}
+
+			if len(releases) != tt.wantAdmit {
+				t.Errorf("admitted %d connections, want %d", len(releases), tt.wantAdmit)
+			}
+			if got := tt.server.LimitStats(); got != tt.wantStats {
+				t.Errorf("LimitStats() = %+v, want %+v", got, tt.wantStats)
+			}
+			if lastRejected != nil && (lastRejected.code != http.StatusServiceUnavailable || lastRejected.reason != tt.wantReason) {
+				t.Errorf("rejected with %d %q, want 503 %q", lastRejected.code, lastRejected.reason, tt.wantReason)
+			}
+
+			// Releasing every connection frees all slots again.
+			for _, release := range releases {
+				release()
+			}
+			c1, c2 := net.Pipe()
+			defer c1.Close()
+			if _, rejected := tt.server.admitConn(c2); rejected != nil {
+				t.Errorf("admitConn() after release = %v, want admitted", rejected)
+			}
+		})
+	}
+}
+
+func TestAcquireConnSlot_Queue(t *testing.T) {
+	server := &server{maxConns: 1, queueConns: true}
+	if !server.acquireConnSlot(true) {
+		t.Fatal("acquireConnSlot() = false for a free slot")
+	}
+
+	acquired := make(chan bool, 1)
+	go func() {
+		acquired <- server.acquireConnSlot(true)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquireConnSlot() returned while every slot was taken")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	server.releaseConnSlot()
+	if ok := <-acquired; !ok {
+		t.Error("queued acquireConnSlot() = false after a slot was released")
+	}
+	if got := server.LimitStats().QueuedConns; got != 1 {
+		t.Errorf("QueuedConns = %d, want 1", got)
+	}
+
+	// Shutdown releases a queued accept.
+	go func() {
+		acquired <- server.acquireConnSlot(true)
+	}()
+	server.Shutdown(context.Background())
+	if ok := <-acquired; ok {
+		t.Error("acquireConnSlot() = true after Shutdown")
+	}
+}
+
+func TestHandleConn_MaxRequestsPerConn(t *testing.T) {
+	server := createTestServer(t)
+	server.maxRequestsPerConn = 2
+	server.Register("GET", "/{$}", server.rootGet)
+
+	client, done := serveConn(t, server)
+	client.SetDeadline(time.Now().Add(2 * time.Second))
+	go client.Write([]byte("GET / HTTP/1.1\r\n\r\nGET / HTTP/1.1\r\n\r\n"))
+
+	br := bufio.NewReader(client)
+	for i, wantClose := range []bool{false, true} {
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatalf("ReadResponse() #%d error = %v", i+1, err)
+		}
+		if resp.Close != wantClose {
+			t.Errorf("response #%d Close = %v, want %v", i+1, resp.Close, wantClose)
+		}
+	}
+	waitClosed(t, done, time.Second)
+
+	if got := server.LimitStats().MaxRequestsReached; got != 1 {
+		t.Errorf("MaxRequestsReached = %d, want 1", got)
+	}
+}
+
+func TestServer_Start_RejectsOverMaxConns(t *testing.T) {
+	listener := createMockTCPListener(t)
+	defer listener.Close()
+
+	server := NewServer(t.TempDir(), listener, make(chan os.Signal, 1))
+	server.maxConns = 1
+	server.Register("GET", "/{$}", server.rootGet)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Start(ctx)
+
+	// The first connection stays open after its request.
+	first, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer first.Close()
+	first.SetDeadline(time.Now().Add(2 * time.Second))
+	first.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	if _, err := http.ReadResponse(bufio.NewReader(first), nil); err != nil {
+		t.Fatalf("ReadResponse() error = %v", err)
+	}
+
+	second, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer second.Close()
+	second.SetDeadline(time.Now().Add(2 * time.Second))
+	resp, err := http.ReadResponse(bufio.NewReader(second), nil)
+	if err != nil {
+		t.Fatalf("ReadResponse() error = %v", err)
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable || !resp.Close {
+		t.Errorf("second connection got %d (close %v), want 503 with Connection: close", resp.StatusCode, resp.Close)
+	}
+	if got := server.LimitStats().RejectedConns; got != 1 {
+		t.Errorf("RejectedConns = %d, want 1", got)
+	}
+}
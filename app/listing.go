@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultListLimit is the page size of a directory listing without a
+	// limit parameter, and maxListLimit the largest one accepted.
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+// Sort keys of a directory listing, selected with the sort parameter.
+const (
+	listSortName  = "name"
+	listSortSize  = "size"
+	listSortMtime = "mtime"
+)
+
+// listEntry describes one entry of a directory listing.
+type listEntry struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Type    string    `json:"type"`
+}
+
+// listing is one page of a directory listing, as rendered in JSON and HTML.
+type listing struct {
+	Path    string      `json:"path"`
+	Entries []listEntry `json:"entries"`
+	Total   int         `json:"total"`
+	Offset  int         `json:"offset"`
+	Limit   int         `json:"limit"`
+
+	// Prev and Next link to the neighbouring pages, if there are any.
+	Prev string `json:"prev,omitempty"`
+	Next string `json:"next,omitempty"`
+}
+
+// listOptions are the query parameters of a directory listing.
+type listOptions struct {
+	sort   string
+	desc   bool
+	offset int
+	limit  int
+}
+
+// parseListOptions reads the sort, order, offset and limit parameters of
+// req, rejecting unknown or out-of-range values.
+func parseListOptions(req *Request) (listOptions, error) {
+	q := req.Query()
+	opts := listOptions{sort: listSortName, limit: defaultListLimit}
+
+	if v := q.Get("sort"); v != "" {
+		switch v {
+		case listSortName, listSortSize, listSortMtime:
+			opts.sort = v
+		default:
+			return opts, newStatusError(http.StatusBadRequest, "invalid sort %q, want name, size or mtime", v)
+		}
+	}
+	switch v := q.Get("order"); v {
+	case "", "asc":
+	case "desc":
+		opts.desc = true
+	default:
+		return opts, newStatusError(http.StatusBadRequest, "invalid order %q, want asc or desc", v)
+	}
+
+	var err error
+	if v := q.Get("offset"); v != "" {
+		if opts.offset, err = strconv.Atoi(v); err != nil || opts.offset < 0 {
+			return opts, newStatusError(http.StatusBadRequest, "invalid offset %q", v)
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if opts.limit, err = strconv.Atoi(v); err != nil || opts.limit < 1 || opts.limit > maxListLimit {
+			return opts, newStatusError(http.StatusBadRequest, "invalid limit %q, want 1 to %d", v, maxListLimit)
+		}
+	}
+	return opts, nil
+}
+
+// pageLink returns the link to the page of the listing at path starting at
+// offset.
+func (opts listOptions) pageLink(path string, offset int) string {
+	q := url.Values{}
+	q.Set("sort", opts.sort)
+	if opts.desc {
+		q.Set("order", "desc")
+	}
+	q.Set("offset", strconv.Itoa(offset))
+	q.Set("limit", strconv.Itoa(opts.limit))
+	return path + "?" + q.Encode()
+}
+
+// readListing reads the entries of the directory dir, named name, and
+// returns the page selected by opts. Dotfiles are left out unless the
+// server shows hidden files.
+func (s *server) readListing(dir *os.File, name string, opts listOptions) (*listing, error) {
+	dirEntries, err := dir.ReadDir(-1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	entries := make([]listEntry, 0, len(dirEntries))
+	for _, de := range dirEntries {
+		if !s.showHidden && strings.HasPrefix(de.Name(), ".") {
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			// The entry was removed since the directory was read.
+			continue
+		}
+		entries = append(entries, listEntry{
+			Name:    de.Name(),
+			Size:    info.Size(),
+			ModTime: info.ModTime().UTC(),
+			Type:    entryType(de.Type()),
+		})
+	}
+
+	slices.SortFunc(entries, func(a, b listEntry) int {
+		var c int
+		switch opts.sort {
+		case listSortSize:
+			c = cmp.Compare(a.Size, b.Size)
+		case listSortMtime:
+			c = a.ModTime.Compare(b.ModTime)
+		}
+		if c == 0 {
+			c = strings.Compare(a.Name, b.Name)
+		}
+		if opts.desc {
+			return -c
+		}
+		return c
+	})
+
+	l := &listing{
+		Path:   fileLocation(name),
+		Total:  len(entries),
+		Offset: opts.offset,
+		Limit:  opts.limit,
+	}
+	start := min(opts.offset, len(entries))
+	end := min(start+opts.limit, len(entries))
+	l.Entries = entries[start:end]
+	if start > 0 {
+		l.Prev = opts.pageLink(l.Path, max(start-opts.limit, 0))
+	}
+	if end < len(entries) {
+		l.Next = opts.pageLink(l.Path, end)
+	}
+	return l, nil
+}
+
+func entryType(mode fs.FileMode) string {
+	switch {
+	case mode.IsDir():
+		return "dir"
+	case mode&fs.ModeSymlink != 0:
+		return "symlink"
+	case mode.IsRegular():
+		return "file"
+	}
+	return "other"
+}
+
+// listDir answers a GET of the directory dir, named name, with a listing in
+// JSON if the client prefers it and in HTML otherwise. Directory names
+// without a trailing slash are redirected to one, so that the relative
+// links of the HTML listing resolve inside the directory.
+func (s *server) listDir(w ResponseWriter, req *Request, dir *os.File, name string) error {
+	if name != "" && !strings.HasSuffix(name, "/") {
+		location := fileLocation(name + "/")
+		if req.RawQuery != "" {
+			location += "?" + req.RawQuery
+		}
+		w.Header().Set(HeaderLocation, location)
+		return httpResponse(w, http.StatusMovedPermanently, "")
+	}
+
+	opts, err := parseListOptions(req)
+	if err != nil {
+		return fileError(w, err)
+	}
+	l, err := s.readListing(dir, name, opts)
+	if err != nil {
+		return fileError(w, err)
+	}
+
+	w.Header().Set(HeaderVary, HeaderAccept)
+	var buf bytes.Buffer
+	if prefersJSON(req) {
+		w.Header().Set(HeaderContentType, ContentTypeApplicationJSON)
+		err = json.NewEncoder(&buf).Encode(l)
+	} else {
+		w.Header().Set(HeaderContentType, ContentTypeTextHTML)
+		err = listingTemplate.Execute(&buf, l)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to render listing: %w", err)
+	}
+	return httpResponse(w, http.StatusOK, buf.Bytes())
+}
+
+// prefersJSON reports whether the Accept header of req ranks
+// application/json above text/html. Without an Accept header, or when both
+// are equally acceptable, HTML is preferred.
+func prefersJSON(req *Request) bool {
+	accept, ok := req.Headers.joined(HeaderAccept)
+	if !ok {
+		return false
+	}
+
+	// The quality of each type is that of the most specific range
+	// matching it.
+	var jsonQ, htmlQ float64
+	jsonSpec, htmlSpec := -1, -1
+	for r := range strings.SplitSeq(accept, ",") {
+		mediaType, params, _ := strings.Cut(r, ";")
+		mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+		q := 1.0
+		for p := range strings.SplitSeq(params, ";") {
+			k, v, _ := strings.Cut(p, "=")
+			if strings.TrimSpace(k) == "q" {
+				if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+					q = f
+				}
+			}
+		}
+
+		if spec := mediaRangeSpecificity(mediaType, "application/json"); spec > jsonSpec {
+			jsonQ, jsonSpec = q, spec
+		}
+		if spec := mediaRangeSpecificity(mediaType, "text/html"); spec > htmlSpec {
+			htmlQ, htmlSpec = q, spec
+		}
+	}
+	return jsonQ > 0 && jsonQ > htmlQ
+}
+
+// mediaRangeSpecificity returns how specifically the media range r matches
+// mediaType: 2 for the type itself, 1 for its "type/*", 0 for "*/*" and -1
+// if it does not match.
+func mediaRangeSpecificity(r, mediaType string) int {
+	switch {
+	case r == mediaType:
+		return 2
+	case r == "*/*":
+		return 0
+	case strings.HasSuffix(r, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(r, "*")):
+		return 1
+	}
+	return -1
+}
+
+var listingTemplate = template.Must(template.New("listing").Funcs(template.FuncMap{
+	"href": func(e listEntry) string {
+		name := e.Name
+		if e.Type == "dir" {
+			name += "/"
+		}
+		// A leading "./" keeps names containing a colon from being read
+		// as a URL scheme.
+		return "./" + (&url.URL{Path: name}).EscapedPath()
+	},
+	"mtime": func(t time.Time) string { return t.Format(http.TimeFormat) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Index of {{.Path}}</title></head>
+<body>
+<h1>Index of {{.Path}}</h1>
+<table>
+<tr><th>Name</th><th>Size</th><th>Modified</th><th>Type</th></tr>
+{{range .Entries}}<tr><td><a href="{{href .}}">{{.Name}}</a></td><td>{{.Size}}</td><td>{{mtime .ModTime}}</td><td>{{.Type}}</td></tr>
+{{end}}</table>
+<p>{{with .Prev}}<a href="{{.}}">Previous</a> {{end}}{{with .Next}}<a href="{{.}}">Next</a>{{end}}</p>
+</body>
+</html>
+`))
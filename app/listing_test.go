@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// createListingDir fills the server's directory with files of distinct
+// sizes and modification times, a subdirectory and a dotfile.
+func createListingDir(t *testing.T, server *server) {
+	t.Helper()
+	base := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	files := []struct {
+		name    string
+		content string
+		age     time.Duration
+	}{
+		{name: "b.txt", content: "bb", age: 3 * time.Hour},
+		{name: "a.txt", content: "aaa", age: 1 * time.Hour},
+		{name: "c.txt", content: "c", age: 2 * time.Hour},
+		{name: ".hidden", content: "h", age: 0},
+	}
+	for _, f := range files {
+		path := filepath.Join(server.dir, f.name)
+		if err := os.WriteFile(path, []byte(f.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		mtime := base.Add(-f.age)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(server.dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFilesGet_ListingJSON(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		showHidden bool
+		wantNames  []string
+		wantNext   bool
+		wantPrev   bool
+	}{
+		{name: "Default", wantNames: []string{"a.txt", "b.txt", "c.txt", "sub"}},
+		{name: "Show hidden", showHidden: true, wantNames: []string{".hidden", "a.txt", "b.txt", "c.txt", "sub"}},
+		{name: "Descending", query: "?order=desc", wantNames: []string{"sub", "c.txt", "b.txt", "a.txt"}},
+		{name: "By mtime", query: "?sort=mtime", wantNames: []string{"b.txt", "c.txt", "a.txt", "sub"}},
+		// Directories are larger than the few bytes of each file.
+		{name: "By size", query: "?sort=size", wantNames: []string{"c.txt", "b.txt", "a.txt", "sub"}},
+		{name: "First page", query: "?limit=2", wantNames: []string{"a.txt", "b.txt"}, wantNext: true},
+		{name: "Last page", query: "?limit=2&offset=2", wantNames: []string{"c.txt", "sub"}, wantPrev: true},
+		{name: "Past the end", query: "?offset=10", wantNames: []string{}, wantPrev: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := createTestServer(t)
+			server.showHidden = tt.showHidden
+			createListingDir(t, server)
+
+			resp, body := getFile(t, server, "GET", tt.query, map[string]string{"Accept": "application/json"})
+			if resp.StatusCode != 200 {
+				t.Fatalf("status = %d, want 200: %s", resp.StatusCode, body)
+			}
+			if got := resp.Header.Get("Content-Type"); got != ContentTypeApplicationJSON {
+				t.Errorf("Content-Type = %q, want %q", got, ContentTypeApplicationJSON)
+			}
+
+			var l listing
+			if err := json.Unmarshal([]byte(body), &l); err != nil {
+				t.Fatalf("invalid JSON %s: %v", body, err)
+			}
+			names := []string{}
+			for _, e := range l.Entries {
+				names = append(names, e.Name)
+			}
+			if strings.Join(names, ",") != strings.Join(tt.wantNames, ",") {
+				t.Errorf("entries = %v, want %v", names, tt.wantNames)
+			}
+			if (l.Next != "") != tt.wantNext || (l.Prev != "") != tt.wantPrev {
+				t.Errorf("prev = %q, next = %q, want prev %v, next %v", l.Prev, l.Next, tt.wantPrev, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestFilesGet_ListingEntries(t *testing.T) {
+	server := createTestServer(t)
+	createListingDir(t, server)
+
+	_, body := getFile(t, server, "GET", "", map[string]string{"Accept": "application/json"})
+	var l listing
+	if err := json.Unmarshal([]byte(body), &l); err != nil {
+		t.Fatalf("invalid JSON %s: %v", body, err)
+	}
+	if l.Path != "/files/" || l.Total != 4 {
+		t.Errorf("path = %q, total = %d, want /files/ and 4", l.Path, l.Total)
+	}
+
+	a := l.Entries[0]
+	wantTime := time.Date(2023, time.December, 31, 23, 0, 0, 0, time.UTC)
+	if a.Name != "a.txt" || a.Size != 3 || a.Type != "file" || !a.ModTime.Equal(wantTime) {
+		t.Errorf("entry = %+v, want a.txt, 3 bytes, file, %v", a, wantTime)
+	}
+	if sub := l.Entries[3]; sub.Type != "dir" {
+		t.Errorf("sub type = %q, want dir", sub.Type)
+	}
+}
+
+func TestFilesGet_ListingHTML(t *testing.T) {
+	server := createTestServer(t)
+	createListingDir(t, server)
+	if err := os.WriteFile(filepath.Join(server.dir, "<x> y.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, body := getFile(t, server, "GET", "?limit=2", map[string]string{"Accept": "text/html,application/json;q=0.9"})
+	if got := resp.Header.Get("Content-Type"); got != ContentTypeTextHTML {
+		t.Errorf("Content-Type = %q, want %q", got, ContentTypeTextHTML)
+	}
+	if got := resp.Header.Get("Vary"); got != "Accept" {
+		t.Errorf("Vary = %q, want Accept", got)
+	}
+	for _, want := range []string{
+		`<a href="./%3Cx%3E%20y.txt">&lt;x&gt; y.txt</a>`,
+		`<a href="./a.txt">a.txt</a>`,
+		`href="/files/?limit=2&amp;offset=2&amp;sort=name">Next</a>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("listing does not contain %s:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, ".hidden") {
+		t.Errorf("listing shows a dotfile:\n%s", body)
+	}
+}
+
+func TestFilesGet_ListingErrors(t *testing.T) {
+	server := createTestServer(t)
+	createListingDir(t, server)
+
+	tests := []struct {
+		name         string
+		target       string
+		wantCode     int
+		wantLocation string
+	}{
+		{name: "Directory without slash", target: "sub?limit=5", wantCode: 301, wantLocation: "/files/sub/?limit=5"},
+		{name: "Subdirectory", target: "sub/", wantCode: 200},
+		{name: "Invalid sort", target: "?sort=color", wantCode: 400},
+		{name: "Invalid order", target: "?order=up", wantCode: 400},
+		{name: "Negative offset", target: "?offset=-1", wantCode: 400},
+		{name: "Limit too large", target: "?limit=5000", wantCode: 400},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, _ := getFile(t, server, "GET", tt.target, nil)
+			if resp.StatusCode != tt.wantCode {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantCode)
+			}
+			if got := resp.Header.Get("Location"); got != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", got, tt.wantLocation)
+			}
+		})
+	}
+}
+
+func TestPrefersJSON(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{accept: "", want: false},
+		{accept: "application/json", want: true},
+		{accept: "text/html", want: false},
+		{accept: "*/*", want: false},
+		{accept: "application/json, text/html", want: false},
+		{accept: "application/json, text/html;q=0.5", want: true},
+		{accept: "application/*", want: true},
+		{accept: "application/json;q=0, */*", want: false},
+		{accept: "text/html;q=0.1, */*;q=0.5", want: true},
+	}
+
+	for _, tt := range tests {
+		headers := map[string]string{}
+		if tt.accept != "" {
+			headers["Accept"] = tt.accept
+		}
+		req := createTestRequest("GET", "/files/", "HTTP/1.1", headers, nil)
+		if got := prefersJSON(req); got != tt.want {
+			t.Errorf("prefersJSON(%q) = %v, want %v", tt.accept, got, tt.want)
+		}
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
 func main() {
@@ -24,21 +25,113 @@ func main() {
 		os.Exit(1)
 	}
 
-	var dir string
+	var (
+		dir            string
+		maxHeaderBytes int
+		maxBodyBytes   int64
+		maxPipelined   int
+		lenient        bool
+
+		maxConns           int
+		maxConnsPerIP      int
+		maxRequestsPerConn int
+		queueConns         bool
+		metricsPath        string
+
+		etagMode        string
+		showHidden      bool
+		accessLogPath   string
+		accessLogFormat string
+
+		readHeaderTimeout time.Duration
+		readTimeout       time.Duration
+		writeTimeout      time.Duration
+		idleTimeout       time.Duration
+		handlerTimeout    time.Duration
+		shutdownTimeout   time.Duration
+	)
 	flag.StringVar(&dir, "directory", "/tmp/", "Directory to look for the files")
+	flag.IntVar(&maxHeaderBytes, "max-header-bytes", defaultMaxHeaderBytes, "Maximum size of a request's request line and headers")
+	flag.Int64Var(&maxBodyBytes, "max-body-bytes", defaultMaxBodyBytes, "Maximum size of a request body")
+	flag.IntVar(&maxPipelined, "max-pipelined", defaultMaxPipelined, "Maximum number of responses to pipelined requests sent in one batch")
+	flag.BoolVar(&lenient, "lenient", false, "Accept malformed requests from legacy clients instead of rejecting them")
+	flag.IntVar(&maxConns, "max-conns", 0, "Maximum number of open connections, 0 for no limit")
+	flag.IntVar(&maxConnsPerIP, "max-conns-per-ip", 0, "Maximum number of open connections from one IP address, 0 for no limit")
+	flag.IntVar(&maxRequestsPerConn, "max-requests-per-conn", 0, "Maximum number of requests served on one connection, 0 for no limit")
+	flag.BoolVar(&queueConns, "queue-conns", false, "Wait for a free connection slot instead of answering 503 when --max-conns is reached")
+	flag.StringVar(&metricsPath, "metrics-path", defaultMetricsPath, "Path serving metrics in Prometheus text format, empty to disable")
+	flag.BoolVar(&showHidden, "show-hidden", false, "List dotfiles in directory listings")
+	flag.StringVar(&etagMode, "etag", ETagModTime, "How file ETags are generated: modtime, weak or hash")
+	flag.StringVar(&accessLogPath, "access-log", "", "File to write the access log to, reopened on SIGHUP; stderr if empty")
+	flag.StringVar(&accessLogFormat, "access-log-format", LogFormatCommon, "Access log format: common, combined or json")
+	flag.DurationVar(&readHeaderTimeout, "read-header-timeout", defaultReadHeaderTimeout, "Maximum time to read a request's line and headers")
+	flag.DurationVar(&readTimeout, "read-timeout", defaultReadTimeout, "Maximum time to read a request body")
+	flag.DurationVar(&writeTimeout, "write-timeout", defaultWriteTimeout, "Maximum time for a single write of a response before the client is dropped")
+	flag.DurationVar(&idleTimeout, "idle-timeout", defaultIdleTimeout, "Maximum time a keep-alive connection waits for the next request")
+	flag.DurationVar(&handlerTimeout, "handler-timeout", defaultHandlerTimeout, "Maximum time a handler may run before its context is cancelled")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", defaultShutdownTimeout, "Maximum time to wait for in-flight requests when shutting down")
 	flag.Parse()
 
+	switch etagMode {
+	case ETagModTime, ETagWeak, ETagHash:
+	default:
+		log.Printf("Invalid --etag %q: want modtime, weak or hash", etagMode)
+		os.Exit(1)
+	}
+
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 	shutdownCh := make(chan os.Signal, 1)
 	signal.Notify(shutdownCh, syscall.SIGINT, syscall.SIGTERM)
 
 	srv := NewServer(dir, tcpL, shutdownCh)
-	srv.Register(http.MethodGet, "/files", srv.filesGet)
-	srv.Register(http.MethodPost, "/files", srv.filesPost)
+	srv.maxHeaderBytes = maxHeaderBytes
+	srv.maxBodyBytes = maxBodyBytes
+	srv.maxPipelined = maxPipelined
+	srv.readHeaderTimeout = readHeaderTimeout
+	srv.readTimeout = readTimeout
+	srv.writeTimeout = writeTimeout
+	srv.idleTimeout = idleTimeout
+	srv.handlerTimeout = handlerTimeout
+	srv.shutdownTimeout = shutdownTimeout
+	srv.lenient = lenient
+	srv.maxConns = maxConns
+	srv.maxConnsPerIP = maxConnsPerIP
+	srv.maxRequestsPerConn = maxRequestsPerConn
+	srv.queueConns = queueConns
+	srv.etagMode = etagMode
+	srv.showHidden = showHidden
+
+	accessLog, err := newAccessLog(accessLogFormat, accessLogPath)
+	if err != nil {
+		log.Println("Failed to set up access log: ", err.Error())
+		os.Exit(1)
+	}
+	defer accessLog.Close()
+	srv.accessLog = accessLog
+
+	// Reopen the access log on SIGHUP so that it can be rotated.
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	go func() {
+		for range hupCh {
+			if err := accessLog.Reopen(); err != nil {
+				log.Println("Failed to reopen access log: ", err.Error())
+			}
+		}
+	}()
+
+	srv.Register(http.MethodGet, "/files/{name...}", srv.filesGet)
+	srv.Register(http.MethodPost, "/files/{name...}", srv.filesPost)
+	srv.Register(http.MethodPut, "/files/{name...}", srv.filesPut)
+	srv.Register(http.MethodPatch, "/files/{name...}", srv.filesPatch)
+	srv.Register(http.MethodDelete, "/files/{name...}", srv.filesDelete)
 	srv.Register(http.MethodGet, "/user-agent", srv.userAgentGet)
-	srv.Register(http.MethodGet, "/echo", srv.echoGet)
-	srv.Register(http.MethodGet, "/", srv.rootGet)
+	srv.Register(http.MethodGet, "/echo/{text...}", srv.echoGet)
+	srv.Register(http.MethodGet, "/{$}", srv.rootGet)
+	if metricsPath != "" {
+		srv.Register(http.MethodGet, metricsPattern(metricsPath), srv.metricsGet)
+	}
 
 	if err := srv.Start(ctx); err != nil {
 		log.Println("Failed to start server: ", err.Error())
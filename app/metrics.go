@@ -0,0 +1,241 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultMetricsPath is where main serves the metrics by default.
+const defaultMetricsPath = "/metrics"
+
+// metricsPattern returns the route pattern serving the metrics at path
+// alone, rather than also every path below it.
+func metricsPattern(path string) string {
+	return strings.TrimSuffix(path, "/") + "/" + exactMarker
+}
+
+// ContentTypePrometheus is the content type of the Prometheus text
+// exposition format.
+const ContentTypePrometheus = "text/plain; version=0.0.4; charset=utf-8"
+
+// latencyBuckets are the upper bounds, in seconds, of the request latency
+// histogram.
+var latencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// routeKey identifies a route in the per-route request counts. pattern is
+// empty for requests that matched no route.
+type routeKey struct {
+	method  string
+	pattern string
+}
+
+// metrics is the server's in-process metrics registry. Connection gauges
+// are not stored here but computed from the tracked connections when the
+// metrics are written.
+type metrics struct {
+	connsAccepted atomic.Int64
+	bytesIn       atomic.Int64
+	bytesOut      atomic.Int64
+
+	mu       sync.Mutex
+	requests map[routeKey]int64
+	statuses map[int]int64
+	reused   int64 // requests that were not the first on their connection
+	buckets  []int64
+	count    int64
+	sum      time.Duration
+}
+
+// observeRequest records a request answered with status after d. reused
+// is set when the request arrived on a kept-alive connection.
+func (m *metrics) observeRequest(method, pattern string, status int, d time.Duration, reused bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.requests == nil {
+		m.requests = make(map[routeKey]int64)
+		m.statuses = make(map[int]int64)
+		m.buckets = make([]int64, len(latencyBuckets))
+	}
+
+	m.requests[routeKey{method, pattern}]++
+	m.statuses[status]++
+	if reused {
+		m.reused++
+	}
+	for i, le := range latencyBuckets {
+		if d.Seconds() <= le {
+			m.buckets[i]++
+		}
+	}
+	m.count++
+	m.sum += d
+}
+
+// observeRejected records a request rejected before it could be routed.
+func (m *metrics) observeRejected(status int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.statuses == nil {
+		m.statuses = make(map[int]int64)
+	}
+	m.statuses[status]++
+}
+
+// countingConn counts the bytes read from and written to a connection.
+type countingConn struct {
+	net.Conn
+	m *metrics
+}
+
+func (c countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.m.bytesIn.Add(int64(n))
+	return n, err
+}
+
+func (c countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.m.bytesOut.Add(int64(n))
+	return n, err
+}
+
+// connCounts returns the number of tracked connections in each state.
+func (s *server) connCounts() (active, idle int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, state := range s.conns {
+		if state == stateIdle {
+			idle++
+		} else {
+			active++
+		}
+	}
+	return active, idle
+}
+
+// writeMetrics writes the server's metrics to w in the Prometheus text
+// exposition format.
+func (s *server) writeMetrics(w io.Writer) error {
+	p := &promWriter{w: w}
+	m := &s.metrics
+
+	active, idle := s.connCounts()
+	p.metric("http_connections_accepted_total", "counter", "Connections accepted by the listener.")
+	p.sample("http_connections_accepted_total", "", m.connsAccepted.Load())
+	p.metric("http_connections_active", "gauge", "Connections reading a request or running a handler.")
+	p.sample("http_connections_active", "", active)
+	p.metric("http_connections_idle", "gauge", "Keep-alive connections waiting for the next request.")
+	p.sample("http_connections_idle", "", idle)
+
+	limits := s.LimitStats()
+	p.metric("http_connections_rejected_total", "counter", "Connections refused by a connection limit.")
+	p.sample("http_connections_rejected_total", label("limit", "max_conns"), limits.RejectedConns)
+	p.sample("http_connections_rejected_total", label("limit", "max_conns_per_ip"), limits.RejectedPerIP)
+	p.metric("http_connections_queued_total", "counter", "Accepts delayed until a connection slot was free.")
+	p.sample("http_connections_queued_total", "", limits.QueuedConns)
+	p.metric("http_connections_max_requests_total", "counter", "Connections closed after serving the maximum number of requests.")
+	p.sample("http_connections_max_requests_total", "", limits.MaxRequestsReached)
+
+	p.metric("http_received_bytes_total", "counter", "Bytes read from client connections.")
+	p.sample("http_received_bytes_total", "", m.bytesIn.Load())
+	p.metric("http_sent_bytes_total", "counter", "Bytes written to client connections.")
+	p.sample("http_sent_bytes_total", "", m.bytesOut.Load())
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	routes := make([]routeKey, 0, len(m.requests))
+	for k := range m.requests {
+		routes = append(routes, k)
+	}
+	slices.SortFunc(routes, func(a, b routeKey) int {
+		return cmp.Or(cmp.Compare(a.pattern, b.pattern), cmp.Compare(a.method, b.method))
+	})
+	p.metric("http_requests_total", "counter", "Requests handled, by method and route pattern.")
+	for _, k := range routes {
+		p.sample("http_requests_total", label("method", k.method)+","+label("route", k.pattern), m.requests[k])
+	}
+
+	codes := make([]int, 0, len(m.statuses))
+	for code := range m.statuses {
+		codes = append(codes, code)
+	}
+	slices.Sort(codes)
+	p.metric("http_responses_total", "counter", "Responses sent, by status code.")
+	for _, code := range codes {
+		p.sample("http_responses_total", label("code", fmt.Sprint(code)), m.statuses[code])
+	}
+
+	p.metric("http_request_duration_seconds", "histogram", "Time from reading a request to finishing its response.")
+	for i, le := range latencyBuckets {
+		var n int64
+		if m.buckets != nil {
+			n = m.buckets[i]
+		}
+		p.sample("http_request_duration_seconds_bucket", label("le", fmt.Sprint(le)), n)
+	}
+	p.sample("http_request_duration_seconds_bucket", label("le", "+Inf"), m.count)
+	p.sample("http_request_duration_seconds_sum", "", m.sum.Seconds())
+	p.sample("http_request_duration_seconds_count", "", m.count)
+
+	ratio := 0.0
+	if m.count > 0 {
+		ratio = float64(m.reused) / float64(m.count)
+	}
+	p.metric("http_requests_reused_total", "counter", "Requests served on a kept-alive connection.")
+	p.sample("http_requests_reused_total", "", m.reused)
+	p.metric("http_keepalive_reuse_ratio", "gauge", "Share of requests served on a kept-alive connection.")
+	p.sample("http_keepalive_reuse_ratio", "", ratio)
+
+	return p.err
+}
+
+// promWriter writes the Prometheus text exposition format, keeping the
+// first write error.
+type promWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (p *promWriter) metric(name, typ, help string) {
+	p.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (p *promWriter) sample(name, labels string, value any) {
+	if labels != "" {
+		name += "{" + labels + "}"
+	}
+	p.printf("%s %v\n", name, value)
+}
+
+func (p *promWriter) printf(format string, args ...any) {
+	if p.err == nil {
+		_, p.err = fmt.Fprintf(p.w, format, args...)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// label formats a name="value" label pair.
+func label(name, value string) string {
+	return name + `="` + labelEscaper.Replace(value) + `"`
+}
+
+// metricsGet serves the server's metrics.
+func (s *server) metricsGet(_ context.Context, req *Request, w ResponseWriter) error {
+	var b strings.Builder
+	if err := s.writeMetrics(&b); err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	w.Header().Set(HeaderContentType, ContentTypePrometheus)
+	return httpResponse(w, http.StatusOK, b.String())
+}
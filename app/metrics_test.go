@@ -0,0 +1,130 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	server := createTestServer(t)
+	server.metrics.connsAccepted.Add(2)
+	server.limitCounters.rejectedPerIP.Add(1)
+	server.metrics.observeRequest("GET", "/echo/{text...}", 200, 3*time.Millisecond, false)
+	server.metrics.observeRequest("GET", "/echo/{text...}", 200, 30*time.Millisecond, true)
+	server.metrics.observeRequest("POST", "/files/{name...}", 201, 2*time.Second, true)
+	server.metrics.observeRequest("GET", "", 404, time.Millisecond/2, false)
+	server.metrics.observeRejected(400)
+
+	var b strings.Builder
+	if err := server.writeMetrics(&b); err != nil {
+		t.Fatalf("writeMetrics() error = %v", err)
+	}
+	out := b.String()
+
+	for _, want := range []string{
+		"# TYPE http_connections_accepted_total counter\nhttp_connections_accepted_total 2\n",
+		"http_connections_active 0\n",
+		`http_connections_rejected_total{limit="max_conns_per_ip"} 1` + "\n",
+		`http_requests_total{method="GET",route=""} 1` + "\n",
+		`http_requests_total{method="GET",route="/echo/{text...}"} 2` + "\n",
+		`http_requests_total{method="POST",route="/files/{name...}"} 1` + "\n",
+		`http_responses_total{code="200"} 2` + "\n",
+		`http_responses_total{code="400"} 1` + "\n",
+		`http_responses_total{code="404"} 1` + "\n",
+		"# TYPE http_request_duration_seconds histogram\n",
+		`http_request_duration_seconds_bucket{le="0.001"} 1` + "\n",
+		`http_request_duration_seconds_bucket{le="0.005"} 2` + "\n",
+		`http_request_duration_seconds_bucket{le="0.05"} 3` + "\n",
+		`http_request_duration_seconds_bucket{le="2.5"} 4` + "\n",
+		`http_request_duration_seconds_bucket{le="+Inf"} 4` + "\n",
+		"http_request_duration_seconds_count 4\n",
+		"http_requests_reused_total 2\n",
+		"http_keepalive_reuse_ratio 0.5\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("writeMetrics() output is missing %q", want)
+		}
+	}
+
+	// Every line is a comment or a sample.
+	sample := regexp.MustCompile(`^[a-z_]+(\{.*\})? \S+$`)
+	for _, line := range strings.Split(strings.TrimSuffix(out, "\n"), "\n") {
+		if !strings.HasPrefix(line, "# ") && !sample.MatchString(line) {
+			t.Errorf("malformed exposition line %q", line)
+		}
+	}
+}
+
+func TestLabel_Escaping(t *testing.T) {
+	got := label("route", "a\"b\\c\nd")
+	want := `route="a\"b\\c\nd"`
+	if got != want {
+		t.Errorf("label() = %s, want %s", got, want)
+	}
+}
+
+func TestServer_MetricsEndpoint(t *testing.T) {
+	server := createTestServer(t)
+	server.Register("GET", "/echo/{text...}", server.echoGet)
+	server.Register("GET", "/metrics", server.metricsGet)
+
+	client, br := startConn(t, server)
+	go client.Write([]byte("GET /echo/hi HTTP/1.1\r\n\r\n"))
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("ReadResponse() error = %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+
+	go client.Write([]byte("GET /metrics HTTP/1.1\r\n\r\n"))
+	resp, err = http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("ReadResponse() error = %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+
+	if ct := resp.Header.Get("Content-Type"); ct != ContentTypePrometheus {
+		t.Errorf("Content-Type = %q, want %q", ct, ContentTypePrometheus)
+	}
+	for _, want := range []string{
+		`http_requests_total{method="GET",route="/echo/{text...}"} 1`,
+		`http_responses_total{code="200"} 1`,
+		"http_connections_active 1",
+		"http_requests_reused_total 0",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics are missing %q", want)
+		}
+	}
+	if regexp.MustCompile(`http_received_bytes_total 0\n`).Match(body) {
+		t.Error("http_received_bytes_total = 0, want the bytes of both requests")
+	}
+}
+
+func TestMetricsPattern(t *testing.T) {
+	tests := []struct {
+		path      string
+		lookup    string
+		wantMatch bool
+	}{
+		{path: "/metrics", lookup: "/metrics", wantMatch: true},
+		{path: "/metrics", lookup: "/metrics/", wantMatch: true},
+		{path: "/metrics", lookup: "/metrics/anything", wantMatch: false},
+		{path: "/internal/metrics/", lookup: "/internal/metrics", wantMatch: true},
+		{path: "/internal/metrics/", lookup: "/internal/metrics/x", wantMatch: false},
+	}
+
+	for _, tt := range tests {
+		root := newNode()
+		if err := root.add(match{method: "GET", pattern: metricsPattern(tt.path)}); err != nil {
+			t.Fatalf("add(%q) error = %v", metricsPattern(tt.path), err)
+		}
+		if r, _ := root.lookup("GET", tt.lookup); (r != nil) != tt.wantMatch {
+			t.Errorf("metrics at %s: lookup(%q) matched = %v, want %v", tt.path, tt.lookup, r != nil, tt.wantMatch)
+		}
+	}
+}
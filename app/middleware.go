@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+)
+
+// Middleware wraps a handler with cross-cutting behaviour. It may act before
+// and after calling next, or answer the request itself without calling next
+// at all.
+type Middleware func(next handleFunc) handleFunc
+
+// chain wraps h so that mw[0] runs first and h runs last.
+func chain(h handleFunc, mw ...Middleware) handleFunc {
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+	return h
+}
+
+// Use appends global middleware that runs for every request, including
+// those answered with 404, 405 or an automatic OPTIONS response. Global
+// middleware runs before any per-route middleware, in the order given.
+func (s *server) Use(mw ...Middleware) {
+	s.middleware = append(s.middleware, mw...)
+}
+
+// responseObserver records the status code and number of body bytes written
+// through it, for middleware that needs to report on the response.
+type responseObserver struct {
+	ResponseWriter
+	status int
+	bytes  int64
+}
+
+func observe(w ResponseWriter) *responseObserver {
+	return &responseObserver{ResponseWriter: w}
+}
+
+func (o *responseObserver) WriteHeader(code int) {
+	if o.status == 0 {
+		o.status = code
+	}
+	o.ResponseWriter.WriteHeader(code)
+}
+
+func (o *responseObserver) Write(b []byte) (int, error) {
+	if o.status == 0 {
+		o.status = http.StatusOK
+	}
+	n, err := o.ResponseWriter.Write(b)
+	o.bytes += int64(n)
+	return n, err
+}
+
+// Status returns the status code sent by the handler. A handler that never
+// sets one gets 200.
+func (o *responseObserver) Status() int {
+	if o.status == 0 {
+		return http.StatusOK
+	}
+	return o.status
+}
+
+// BytesWritten returns the number of body bytes the handler wrote.
+func (o *responseObserver) BytesWritten() int64 {
+	return o.bytes
+}
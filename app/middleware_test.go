@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// recordMiddleware appends name to calls before and after running next.
+func recordMiddleware(calls *[]string, name string) Middleware {
+	return func(next handleFunc) handleFunc {
+		return func(ctx context.Context, req *Request, w ResponseWriter) error {
+			*calls = append(*calls, name+":before")
+			err := next(ctx, req, w)
+			*calls = append(*calls, name+":after")
+			return err
+		}
+	}
+}
+
+func TestMiddleware_Order(t *testing.T) {
+	server := createTestServer(t)
+
+	var calls []string
+	handler := func(ctx context.Context, req *Request, w ResponseWriter) error {
+		calls = append(calls, "handler")
+		return nil
+	}
+
+	server.Use(recordMiddleware(&calls, "global1"))
+	server.Register("GET", "/test", handler,
+		recordMiddleware(&calls, "route1"),
+		recordMiddleware(&calls, "route2"),
+	)
+	// Global middleware added after registration still applies.
+	server.Use(recordMiddleware(&calls, "global2"))
+
+	var buf bytes.Buffer
+	req := createTestRequest("GET", "/test", "HTTP/1.1", nil, nil)
+	if err := routeRequest(context.Background(), server, req, &buf); err != nil {
+		t.Fatalf("Route() error = %v", err)
+	}
+
+	want := []string{
+		"global1:before", "global2:before",
+		"route1:before", "route2:before",
+		"handler",
+		"route2:after", "route1:after",
+		"global2:after", "global1:after",
+	}
+	if strings.Join(calls, ",") != strings.Join(want, ",") {
+		t.Errorf("call order = %v, want %v", calls, want)
+	}
+}
+
+func TestMiddleware_GlobalRunsForUnmatchedRequests(t *testing.T) {
+	server := createTestServer(t)
+
+	var calls []string
+	server.Use(recordMiddleware(&calls, "global"))
+	server.Register("GET", "/test", func(ctx context.Context, req *Request, w ResponseWriter) error {
+		return nil
+	}, recordMiddleware(&calls, "route"))
+
+	var buf bytes.Buffer
+	req := createTestRequest("GET", "/missing", "HTTP/1.1", nil, nil)
+	if err := routeRequest(context.Background(), server, req, &buf); err != nil {
+		t.Fatalf("Route() error = %v", err)
+	}
+
+	if strings.Join(calls, ",") != "global:before,global:after" {
+		t.Errorf("calls = %v, want only the global middleware", calls)
+	}
+	if !strings.HasPrefix(buf.String(), "HTTP/1.1 404") {
+		t.Errorf("Route() response = %q, want 404", buf.String())
+	}
+}
+
+func TestMiddleware_ShortCircuit(t *testing.T) {
+	server := createTestServer(t)
+
+	requireToken := func(next handleFunc) handleFunc {
+		return func(ctx context.Context, req *Request, w ResponseWriter) error {
+			if v, _ := req.Headers.Get("Authorization"); v != "Bearer secret" {
+				return httpResponse(w, http.StatusUnauthorized, "")
+			}
+			return next(ctx, req, w)
+		}
+	}
+
+	handlerCalled := false
+	server.Register("GET", "/private", func(ctx context.Context, req *Request, w ResponseWriter) error {
+		handlerCalled = true
+		return httpResponse(w, http.StatusOK, "secret data")
+	}, requireToken)
+
+	tests := []struct {
+		name        string
+		headers     map[string]string
+		wantStatus  string
+		wantHandler bool
+	}{
+		{name: "Rejected", wantStatus: "HTTP/1.1 401", wantHandler: false},
+		{name: "Allowed", headers: map[string]string{"Authorization": "Bearer secret"}, wantStatus: "HTTP/1.1 200", wantHandler: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handlerCalled = false
+			var buf bytes.Buffer
+			req := createTestRequest("GET", "/private", "HTTP/1.1", tt.headers, nil)
+			if err := routeRequest(context.Background(), server, req, &buf); err != nil {
+				t.Fatalf("Route() error = %v", err)
+			}
+			if !strings.HasPrefix(buf.String(), tt.wantStatus) {
+				t.Errorf("Route() response = %q, want %s", buf.String(), tt.wantStatus)
+			}
+			if handlerCalled != tt.wantHandler {
+				t.Errorf("handler called = %v, want %v", handlerCalled, tt.wantHandler)
+			}
+		})
+	}
+}
+
+func TestMiddleware_Observe(t *testing.T) {
+	tests := []struct {
+		name       string
+		handler    handleFunc
+		wantStatus int
+		wantBytes  int64
+	}{
+		{
+			name: "Explicit status",
+			handler: func(ctx context.Context, req *Request, w ResponseWriter) error {
+				return httpResponse(w, http.StatusCreated, "created!")
+			},
+			wantStatus: 201,
+			wantBytes:  8,
+		},
+		{
+			name: "Implicit status from Write",
+			handler: func(ctx context.Context, req *Request, w ResponseWriter) error {
+				w.Write([]byte("abc"))
+				w.Write([]byte("de"))
+				return nil
+			},
+			wantStatus: 200,
+			wantBytes:  5,
+		},
+		{
+			name: "Nothing written",
+			handler: func(ctx context.Context, req *Request, w ResponseWriter) error {
+				return nil
+			},
+			wantStatus: 200,
+			wantBytes:  0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var status int
+			var written int64
+			observer := func(next handleFunc) handleFunc {
+				return func(ctx context.Context, req *Request, w ResponseWriter) error {
+					o := observe(w)
+					err := next(ctx, req, o)
+					status, written = o.Status(), o.BytesWritten()
+					return err
+				}
+			}
+
+			server := createTestServer(t)
+			server.Use(observer)
+			server.Register("GET", "/", tt.handler)
+
+			var buf bytes.Buffer
+			req := createTestRequest("GET", "/", "HTTP/1.1", nil, nil)
+			if err := routeRequest(context.Background(), server, req, &buf); err != nil {
+				t.Fatalf("Route() error = %v", err)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("Status() = %d, want %d", status, tt.wantStatus)
+			}
+			if written != tt.wantBytes {
+				t.Errorf("BytesWritten() = %d, want %d", written, tt.wantBytes)
+			}
+		})
+	}
+}
+
+func TestMiddleware_SetsPattern(t *testing.T) {
+	server := createTestServer(t)
+
+	var pattern string
+	server.Use(func(next handleFunc) handleFunc {
+		return func(ctx context.Context, req *Request, w ResponseWriter) error {
+			err := next(ctx, req, w)
+			pattern = req.Pattern
+			return err
+		}
+	})
+	server.Register("GET", "/files/{name...}", func(ctx context.Context, req *Request, w ResponseWriter) error {
+		return nil
+	})
+
+	var buf bytes.Buffer
+	req := createTestRequest("GET", "/files/a.txt", "HTTP/1.1", nil, nil)
+	if err := routeRequest(context.Background(), server, req, &buf); err != nil {
+		t.Fatalf("Route() error = %v", err)
+	}
+	if pattern != "/files/{name...}" {
+		t.Errorf("req.Pattern = %q, want /files/{name...}", pattern)
+	}
+}
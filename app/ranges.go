@@ -0,0 +1,236 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxRanges bounds the number of ranges served in one multipart response;
+// requests asking for more get the whole file instead.
+const maxRanges = 64
+
+// errUnsatisfiableRange is returned by parseRange when none of the requested
+// ranges overlaps the representation.
+var errUnsatisfiableRange = errors.New("no requested range is satisfiable")
+
+// byteRange is a satisfiable range of a representation, with its end
+// already clamped to the representation's size.
+type byteRange struct {
+	start, length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("%s %d-%d/%d", RangeUnitBytes, r.start, r.start+r.length-1, size)
+}
+
+// parseRange parses a Range header value (RFC 9110 section 14.1.2) for a
+// representation of size bytes. It returns no ranges and no error when the
+// header should be ignored: it is malformed, uses another unit, or asks for
+// more than serving the whole representation would send.
+func parseRange(s string, size int64) ([]byteRange, error) {
+	unit, set, ok := strings.Cut(s, "=")
+	if !ok || !strings.EqualFold(strings.TrimSpace(unit), RangeUnitBytes) {
+		return nil, nil
+	}
+
+	var ranges []byteRange
+	var total int64
+	for spec := range strings.SplitSeq(set, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		first, last, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, nil
+		}
+
+		var r byteRange
+		if first == "" {
+			// A suffix range: the last n bytes.
+			n, err := parseRangeInt(last)
+			if err != nil {
+				return nil, nil
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			n = min(n, size)
+			r = byteRange{start: size - n, length: n}
+		} else {
+			start, err := parseRangeInt(first)
+			if err != nil {
+				return nil, nil
+			}
+			end := size - 1
+			if last != "" {
+				if end, err = parseRangeInt(last); err != nil || end < start {
+					return nil, nil
+				}
+				end = min(end, size-1)
+			}
+			if start >= size {
+				continue
+			}
+			r = byteRange{start: start, length: end - start + 1}
+		}
+
+		ranges = append(ranges, r)
+		total += r.length
+	}
+
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiableRange
+	}
+	if len(ranges) > maxRanges || total > size {
+		// Overlapping or excessive ranges would cost more than the whole
+		// file, which is what clients abusing them get instead.
+		return nil, nil
+	}
+	return ranges, nil
+}
+
+func parseRangeInt(s string) (int64, error) {
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return 0, fmt.Errorf("invalid range position %q", s)
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// ifRangeMatches reports whether the If-Range precondition of req holds for
+// a file with the given entity tag and modification time, so that its Range
+// header applies. An entity tag must match strongly and a date exactly.
+func ifRangeMatches(req *Request, etag string, modTime time.Time) bool {
+	v, ok := req.Headers.Get(HeaderIfRange)
+	if !ok {
+		return true
+	}
+	if tag, rest, ok := scanETag(v); ok && rest == "" {
+		return strongETagMatch(tag, etag)
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return false
+	}
+	return t.Equal(modTime.Truncate(time.Second))
+}
+
+// serveFile writes f, which has the given info and entity tag, honoring the
+// request's conditional headers and its Range and If-Range headers. Ranges
+// only apply to GET; a HEAD request gets the header of the full response.
+func serveFile(w ResponseWriter, req *Request, f *os.File, info os.FileInfo, etag string) error {
+	size := info.Size()
+	w.Header().Set(HeaderAcceptRanges, RangeUnitBytes)
+	w.Header().Set(HeaderLastModified, info.ModTime().UTC().Format(http.TimeFormat))
+	w.Header().Set(HeaderETag, etag)
+
+	if code := checkPreconditions(req, etag, info.ModTime(), true); code != 0 {
+		return httpResponse(w, code, "")
+	}
+
+	var ranges []byteRange
+	if rangeHeader, ok := req.Headers.Get(HeaderRange); ok && req.Method == http.MethodGet && ifRangeMatches(req, etag, info.ModTime()) {
+		var err error
+		ranges, err = parseRange(rangeHeader, size)
+		if errors.Is(err, errUnsatisfiableRange) {
+			w.Header().Set(HeaderContentRange, fmt.Sprintf("%s */%d", RangeUnitBytes, size))
+			return httpResponse(w, http.StatusRequestedRangeNotSatisfiable, "")
+		}
+	}
+
+	// The response to HEAD has the same header but no body, so the file
+	// is not read for it.
+	head := req.Method == http.MethodHead
+	switch len(ranges) {
+	case 0:
+		w.Header().Set(HeaderContentType, ContentTypeApplicationOctetStream)
+		w.Header().Set(HeaderContentLength, strconv.FormatInt(size, 10))
+		w.WriteHeader(http.StatusOK)
+		if head {
+			return nil
+		}
+		_, err := io.Copy(w, f)
+		return err
+
+	case 1:
+		r := ranges[0]
+		w.Header().Set(HeaderContentType, ContentTypeApplicationOctetStream)
+		w.Header().Set(HeaderContentRange, r.contentRange(size))
+		w.Header().Set(HeaderContentLength, strconv.FormatInt(r.length, 10))
+		w.WriteHeader(http.StatusPartialContent)
+		if head {
+			return nil
+		}
+		_, err := io.Copy(w, io.NewSectionReader(f, r.start, r.length))
+		return err
+	}
+
+	// Several ranges are sent as multipart/byteranges. The body's length is
+	// known in advance by writing the part headers alone first.
+	boundary := multipart.NewWriter(io.Discard).Boundary()
+	length, err := writeByteRanges(io.Discard, boundary, nil, ranges, size)
+	if err != nil {
+		return err
+	}
+	w.Header().Set(HeaderContentType, "multipart/byteranges; boundary="+boundary)
+	w.Header().Set(HeaderContentLength, strconv.FormatInt(length, 10))
+	w.WriteHeader(http.StatusPartialContent)
+	if head {
+		return nil
+	}
+	_, err = writeByteRanges(w, boundary, f, ranges, size)
+	return err
+}
+
+// writeByteRanges writes ranges of f as a multipart/byteranges body to w and
+// returns its length. With a nil f only the part headers are written, but
+// the returned length includes the ranges.
+func writeByteRanges(w io.Writer, boundary string, f io.ReaderAt, ranges []byteRange, size int64) (int64, error) {
+	cw := &countingWriter{w: w}
+	mw := multipart.NewWriter(cw)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return 0, err
+	}
+
+	var content int64
+	for _, r := range ranges {
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			HeaderContentType:  {ContentTypeApplicationOctetStream},
+			HeaderContentRange: {r.contentRange(size)},
+		})
+		if err != nil {
+			return 0, err
+		}
+		if f == nil {
+			content += r.length
+			continue
+		}
+		if _, err := io.Copy(part, io.NewSectionReader(f, r.start, r.length)); err != nil {
+			return 0, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return 0, err
+	}
+	return cw.n + content, nil
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		size    int64
+		want    []byteRange
+		wantErr error
+	}{
+		{name: "First bytes", header: "bytes=0-4", size: 10, want: []byteRange{{0, 5}}},
+		{name: "Open ended", header: "bytes=7-", size: 10, want: []byteRange{{7, 3}}},
+		{name: "Suffix", header: "bytes=-3", size: 10, want: []byteRange{{7, 3}}},
+		{name: "Suffix longer than file", header: "bytes=-30", size: 10, want: []byteRange{{0, 10}}},
+		{name: "End clamped", header: "bytes=5-100", size: 10, want: []byteRange{{5, 5}}},
+		{name: "Several ranges", header: "bytes=0-1, 4-5,,8-", size: 10, want: []byteRange{{0, 2}, {4, 2}, {8, 2}}},
+		{name: "Unit is case-insensitive", header: "Bytes=1-1", size: 10, want: []byteRange{{1, 1}}},
+		{name: "Unsatisfiable range skipped", header: "bytes=20-30,0-0", size: 10, want: []byteRange{{0, 1}}},
+		{name: "Start past the end", header: "bytes=10-", size: 10, wantErr: errUnsatisfiableRange},
+		{name: "Empty suffix", header: "bytes=-0", size: 10, wantErr: errUnsatisfiableRange},
+		{name: "Empty file", header: "bytes=0-", size: 0, wantErr: errUnsatisfiableRange},
+		{name: "Other unit ignored", header: "items=0-1", size: 10},
+		{name: "Last before first ignored", header: "bytes=5-1", size: 10},
+		{name: "Missing dash ignored", header: "bytes=5", size: 10},
+		{name: "Signed position ignored", header: "bytes=+1-2", size: 10},
+		{name: "Overlapping ranges ignored", header: "bytes=0-7,2-9", size: 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRange(tt.header, tt.size)
+			if err != tt.wantErr {
+				t.Fatalf("parseRange() error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseRange() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// getFile runs filesGet for name with the given request headers and parses
+// the response.
+func getFile(t *testing.T, server *server, method, name string, headers map[string]string) (*http.Response, string) {
+	t.Helper()
+	req := createTestRequest(method, "/files/"+name, "HTTP/1.1", headers, nil)
+	buf := captureResponse(t, server.filesGet, withPathValue(req, "name", "/files/"))
+	resp, err := http.ReadResponse(bufio.NewReader(buf), &http.Request{Method: method})
+	if err != nil {
+		t.Fatalf("ReadResponse() error = %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	return resp, string(body)
+}
+
+func TestFilesGet_Range(t *testing.T) {
+	server := createTestServer(t)
+	content := "0123456789abcdefghij"
+	path := filepath.Join(server.dir, "range.txt")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	lastModified := modTime.Format(http.TimeFormat)
+
+	tests := []struct {
+		name             string
+		method           string
+		headers          map[string]string
+		wantCode         int
+		wantBody         string
+		wantContentRange string
+	}{
+		{name: "No range", headers: nil, wantCode: 200, wantBody: content},
+		{name: "Single range", headers: map[string]string{"Range": "bytes=2-5"}, wantCode: 206, wantBody: "2345", wantContentRange: "bytes 2-5/20"},
+		{name: "Suffix range", headers: map[string]string{"Range": "bytes=-3"}, wantCode: 206, wantBody: "hij", wantContentRange: "bytes 17-19/20"},
+		{name: "Unsatisfiable", headers: map[string]string{"Range": "bytes=50-"}, wantCode: 416, wantContentRange: "bytes */20"},
+		{name: "Malformed range ignored", headers: map[string]string{"Range": "bytes=x-y"}, wantCode: 200, wantBody: content},
+		{name: "If-Range date matches", headers: map[string]string{"Range": "bytes=0-0", "If-Range": lastModified}, wantCode: 206, wantBody: "0", wantContentRange: "bytes 0-0/20"},
+		{name: "If-Range date differs", headers: map[string]string{"Range": "bytes=0-0", "If-Range": "Mon, 01 Jan 2024 00:00:00 GMT"}, wantCode: 200, wantBody: content},
+		{name: "If-Range entity tag", headers: map[string]string{"Range": "bytes=0-0", "If-Range": `"abc"`}, wantCode: 200, wantBody: content},
+		{name: "HEAD ignores Range", method: "HEAD", headers: map[string]string{"Range": "bytes=0-0"}, wantCode: 200},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = "GET"
+			}
+			resp, body := getFile(t, server, method, "range.txt", tt.headers)
+			if resp.StatusCode != tt.wantCode {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantCode)
+			}
+			if body != tt.wantBody {
+				t.Errorf("body = %q, want %q", body, tt.wantBody)
+			}
+			if got := resp.Header.Get("Content-Range"); got != tt.wantContentRange {
+				t.Errorf("Content-Range = %q, want %q", got, tt.wantContentRange)
+			}
+			if tt.wantCode != 416 && resp.Header.Get("Accept-Ranges") != "bytes" {
+				t.Errorf("Accept-Ranges = %q, want bytes", resp.Header.Get("Accept-Ranges"))
+			}
+			if got := resp.Header.Get("Last-Modified"); got != lastModified {
+				t.Errorf("Last-Modified = %q, want %q", got, lastModified)
+			}
+		})
+	}
+}
+
+func TestFilesGet_MultipleRanges(t *testing.T) {
+	server := createTestServer(t)
+	content := "0123456789abcdefghij"
+	if err := os.WriteFile(filepath.Join(server.dir, "multi.txt"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, body := getFile(t, server, "GET", "multi.txt", map[string]string{"Range": "bytes=0-1,10-12,-2"})
+	if resp.StatusCode != http.StatusPartialContent {
+		t.Fatalf("status = %d, want 206", resp.StatusCode)
+	}
+	if n, _ := strconv.Atoi(resp.Header.Get("Content-Length")); n != len(body) {
+		t.Errorf("Content-Length = %d, body has %d bytes", n, len(body))
+	}
+
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/byteranges" {
+		t.Fatalf("Content-Type = %q, want multipart/byteranges", resp.Header.Get("Content-Type"))
+	}
+
+	want := []struct{ contentRange, data string }{
+		{"bytes 0-1/20", "01"},
+		{"bytes 10-12/20", "abc"},
+		{"bytes 18-19/20", "ij"},
+	}
+	mr := multipart.NewReader(strings.NewReader(body), params["boundary"])
+	for i, w := range want {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatalf("part %d: %v", i, err)
+		}
+		data, _ := io.ReadAll(part)
+		if got := part.Header.Get("Content-Range"); got != w.contentRange || string(data) != w.data {
+			t.Errorf("part %d = %q %q, want %q %q", i, got, data, w.contentRange, w.data)
+		}
+	}
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Errorf("extra part after the requested ranges: %v", err)
+	}
+}
+
+func TestServeFile_HeadDoesNotRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "big.bin")
+	if err := os.WriteFile(path, make([]byte, 1<<20), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, _ := f.Stat()
+	// Reading the closed file would fail, so serveFile must not touch it.
+	f.Close()
+
+	req := createTestRequest("HEAD", "/files/big.bin", "HTTP/1.1", nil, nil)
+	var buf bytes.Buffer
+	w := newResponse(&buf, req)
+	if err := serveFile(w, req, f, info, `"tag"`); err != nil {
+		t.Fatalf("serveFile() error = %v", err)
+	}
+	if err := w.finish(); err != nil {
+		t.Fatalf("finish() error = %v", err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(&buf), &http.Request{Method: "HEAD"})
+	if err != nil {
+		t.Fatalf("ReadResponse() error = %v", err)
+	}
+	if resp.StatusCode != 200 || resp.ContentLength != 1<<20 {
+		t.Errorf("status = %d, Content-Length = %d, want 200 and %d", resp.StatusCode, resp.ContentLength, 1<<20)
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultMaxHeaderBytes = 1 << 20  // 1 MiB
+	defaultMaxBodyBytes   = 32 << 20 // 32 MiB
+)
+
+// requestLimits bounds how much of a request is read into memory and how
+// strictly it is validated.
+type requestLimits struct {
+	maxHeaderBytes int
+	maxBodyBytes   int64
+
+	// lenient accepts malformed requests from legacy clients, as described
+	// on parseRequestLine and readHeaders, instead of answering 400.
+	lenient bool
+}
+
+// statusError is returned when a request cannot be read and the client
+// should be answered with the given status code before closing.
+type statusError struct {
+	code   int
+	reason string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.code, http.StatusText(e.code), e.reason)
+}
+
+func newStatusError(code int, format string, args ...any) *statusError {
+	return &statusError{code: code, reason: fmt.Sprintf(format, args...)}
+}
+
+var errHeaderTooLarge = newStatusError(http.StatusRequestHeaderFieldsTooLarge, "request header too large")
+
+// readRequest reads a single request from br. It reads the request line and
+// headers up to the blank line and then the body, either exactly
+// Content-Length bytes or a chunked body, blocking across as many reads as
+// needed. The body of a request sent with Expect: 100-continue is left on
+// br to be read through Request.BodyReader.
+//
+// io.EOF is returned as-is when the connection is closed before any byte of a
+// new request arrives. Malformed or oversized requests yield a *statusError.
+func readRequest(br *bufio.Reader, limits requestLimits) (*Request, error) {
+	r, err := readRequestHead(br, limits)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.readBody(br, limits); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// readRequestHead reads the request line and headers of a request, leaving
+// its body on br.
+func readRequestHead(br *bufio.Reader, limits requestLimits) (*Request, error) {
+	remaining := limits.maxHeaderBytes
+
+	// RFC 9112 section 2.2: ignore at least one empty line before the request
+	// line. An empty line ending in a bare LF is skipped even in strict mode,
+	// since clients built on "echo" commonly leave one between requests.
+	if err := skipEmptyLines(br, &remaining); err != nil {
+		return nil, err
+	}
+	line, err := readLine(br, &remaining, limits.lenient)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	r, err := parseRequestLine(line, limits.lenient)
+	if err != nil {
+		return nil, err
+	}
+
+	headers, err := readHeaders(br, &remaining, limits.lenient)
+	if err != nil {
+		return nil, err
+	}
+	if !limits.lenient {
+		if err := checkSingleFields(headers); err != nil {
+			return nil, err
+		}
+	}
+	r.Headers = headers
+
+	if err := r.checkExpect(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// readHeaders reads header field lines up to and including the blank line
+// that terminates them. Strict mode rejects malformed lines and obs-fold
+// continuation lines with 400; lenient mode skips the former and unfolds the
+// latter into the previous field's value.
+func readHeaders(br *bufio.Reader, remaining *int, lenient bool) (*Headers, error) {
+	headers := NewHeaders()
+	var last string
+	for {
+		l, err := readLine(br, remaining, lenient)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if l == "" {
+			return headers, nil
+		}
+
+		if l[0] == ' ' || l[0] == '\t' {
+			if !lenient {
+				return nil, newStatusError(http.StatusBadRequest, "obsolete line folding in header %q", last)
+			}
+			if last != "" {
+				headers.extendLast(last, " "+strings.Trim(l, " \t"))
+			}
+			continue
+		}
+
+		name, value, ok, err := parseFieldLine(l, lenient)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			// Skip malformed header lines
+			continue
+		}
+		headers.Add(name, value)
+		last = name
+	}
+}
+
+// readBody reads the message body framed by either Transfer-Encoding or
+// Content-Length, following RFC 9112 section 6.3.
+func (r *Request) readBody(br *bufio.Reader, limits requestLimits) error {
+	te, hasTE := r.Headers.joined(HeaderTransferEncoding)
+	cl, hasCL := r.Headers.Get(HeaderContentLength)
+	for _, v := range r.Headers.Values(HeaderContentLength) {
+		if v != cl {
+			return newStatusError(http.StatusBadRequest, "conflicting Content-Length values %q and %q", cl, v)
+		}
+	}
+
+	if hasTE {
+		// A message with both is a classic request smuggling vector; the
+		// recipient is allowed to reject it outright rather than guess.
+		if hasCL {
+			return newStatusError(http.StatusBadRequest, "both Transfer-Encoding and Content-Length present")
+		}
+		if r.Version == "HTTP/1.0" {
+			return newStatusError(http.StatusBadRequest, "Transfer-Encoding not allowed in HTTP/1.0")
+		}
+		codings := strings.Split(te, ",")
+		if !strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), TransferEncodingChunked) {
+			return newStatusError(http.StatusBadRequest, "final transfer coding must be chunked, got %q", te)
+		}
+		if len(codings) > 1 {
+			return newStatusError(http.StatusNotImplemented, "unsupported transfer coding %q", te)
+		}
+		if r.expectContinue {
+			cr := newChunkedReader(br, limits.maxHeaderBytes)
+			cr.lenient = limits.lenient
+			r.body = &bodyReader{r: cr, req: r, length: -1, limit: limits.maxBodyBytes}
+			return nil
+		}
+		return r.readChunkedBody(br, limits)
+	}
+
+	if !hasCL {
+		r.Body = nil
+		r.expectContinue = false
+		return nil
+	}
+
+	n, err := strconv.ParseInt(cl, 10, 64)
+	if err != nil || n < 0 {
+		return newStatusError(http.StatusBadRequest, "invalid Content-Length %q", cl)
+	}
+	if n > limits.maxBodyBytes {
+		return newStatusError(http.StatusRequestEntityTooLarge, "body of %d bytes exceeds limit of %d", n, limits.maxBodyBytes)
+	}
+
+	if n == 0 {
+		r.expectContinue = false
+	}
+	if r.expectContinue {
+		r.body = &bodyReader{r: io.LimitReader(br, n), req: r, length: n, limit: n}
+		return nil
+	}
+
+	r.Body = make([]byte, n)
+	if _, err := io.ReadFull(br, r.Body); err != nil {
+		return fmt.Errorf("failed to read body: %w", unexpectedEOF(err))
+	}
+	return nil
+}
+
+func (r *Request) readChunkedBody(br *bufio.Reader, limits requestLimits) error {
+	cr := newChunkedReader(br, limits.maxHeaderBytes)
+	cr.lenient = limits.lenient
+	body, err := io.ReadAll(io.LimitReader(cr, limits.maxBodyBytes+1))
+	if err != nil {
+		return fmt.Errorf("failed to read chunked body: %w", err)
+	}
+	if int64(len(body)) > limits.maxBodyBytes {
+		return newStatusError(http.StatusRequestEntityTooLarge, "chunked body exceeds limit of %d", limits.maxBodyBytes)
+	}
+
+	r.Body = body
+	r.Trailers = cr.trailers
+	return nil
+}
+
+// readLine reads a CRLF terminated line, charging its length against
+// remaining. A bare LF also ends the line in lenient mode and is rejected
+// with 400 otherwise. The line terminator is not included in the result.
+func readLine(br *bufio.Reader, remaining *int, lenient bool) (string, error) {
+	var line []byte
+	for {
+		frag, err := br.ReadSlice('\n')
+		*remaining -= len(frag)
+		if *remaining < 0 {
+			return "", errHeaderTooLarge
+		}
+		line = append(line, frag...)
+		if err == nil {
+			break
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if len(line) > 0 {
+			return "", io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+
+	line = line[:len(line)-1]
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	} else if !lenient {
+		return "", newStatusError(http.StatusBadRequest, "line terminated by bare LF")
+	}
+	return string(line), nil
+}
+
+// skipEmptyLines discards CRLF and LF line terminators at the start of br,
+// charging them against remaining.
+func skipEmptyLines(br *bufio.Reader, remaining *int) error {
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return err
+		}
+		n := 0
+		switch b[0] {
+		case '\n':
+			n = 1
+		case '\r':
+			if b, err := br.Peek(2); err == nil && b[1] == '\n' {
+				n = 2
+			}
+		}
+		if n == 0 {
+			return nil
+		}
+		*remaining -= n
+		if *remaining < 0 {
+			return errHeaderTooLarge
+		}
+		br.Discard(n)
+	}
+}
+
+// unexpectedEOF turns io.EOF into io.ErrUnexpectedEOF for reads that happen
+// in the middle of a request.
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (s *server) requestLimits() requestLimits {
+	limits := requestLimits{
+		maxHeaderBytes: s.maxHeaderBytes,
+		maxBodyBytes:   s.maxBodyBytes,
+		lenient:        s.lenient,
+	}
+	if limits.maxHeaderBytes <= 0 {
+		limits.maxHeaderBytes = defaultMaxHeaderBytes
+	}
+	if limits.maxBodyBytes <= 0 {
+		limits.maxBodyBytes = defaultMaxBodyBytes
+	}
+	return limits
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func testLimits() requestLimits {
+	return requestLimits{
+		maxHeaderBytes: defaultMaxHeaderBytes,
+		maxBodyBytes:   defaultMaxBodyBytes,
+	}
+}
+
+func TestReadRequest_SplitAcrossReads(t *testing.T) {
+	body := strings.Repeat("x", 64*1024)
+	raw := "POST /files/big HTTP/1.1\r\nHost: localhost\r\nContent-Length: 65536\r\n\r\n" + body
+
+	// OneByteReader forces every header line and the body to span many reads.
+	br := bufio.NewReader(iotest.OneByteReader(strings.NewReader(raw)))
+	req, err := readRequest(br, testLimits())
+	if err != nil {
+		t.Fatalf("readRequest() error = %v", err)
+	}
+
+	if req.Method != "POST" || req.Target != "/files/big" || req.Version != "HTTP/1.1" {
+		t.Errorf("readRequest() request line = %q %q %q", req.Method, req.Target, req.Version)
+	}
+	if host, _ := req.Headers.Get("Host"); host != "localhost" {
+		t.Errorf("readRequest() Host = %q, want localhost", host)
+	}
+	if string(req.Body) != body {
+		t.Errorf("readRequest() body length = %d, want %d", len(req.Body), len(body))
+	}
+}
+
+func TestReadRequest_LeavesNextRequestBuffered(t *testing.T) {
+	raw := "POST /a HTTP/1.1\r\nContent-Length: 3\r\n\r\nabcGET /b HTTP/1.1\r\n\r\n"
+	br := bufio.NewReader(strings.NewReader(raw))
+
+	first, err := readRequest(br, testLimits())
+	if err != nil {
+		t.Fatalf("readRequest() first error = %v", err)
+	}
+	if string(first.Body) != "abc" {
+		t.Errorf("readRequest() first body = %q, want abc", first.Body)
+	}
+
+	second, err := readRequest(br, testLimits())
+	if err != nil {
+		t.Fatalf("readRequest() second error = %v", err)
+	}
+	if second.Target != "/b" {
+		t.Errorf("readRequest() second target = %q, want /b", second.Target)
+	}
+
+	if _, err := readRequest(br, testLimits()); err != io.EOF {
+		t.Errorf("readRequest() at end error = %v, want io.EOF", err)
+	}
+}
+
+func TestReadRequest_RepeatedHeaders(t *testing.T) {
+	raw := "POST /a HTTP/1.1\r\n" +
+		"Accept: text/html\r\n" +
+		"Via: 1.0 first\r\n" +
+		"accept: text/plain\r\n" +
+		"Content-Length: 2\r\n" +
+		"\r\nok"
+
+	req, err := readRequest(bufio.NewReader(strings.NewReader(raw)), testLimits())
+	if err != nil {
+		t.Fatalf("readRequest() error = %v", err)
+	}
+	if got := req.Headers.Values("Accept"); strings.Join(got, "|") != "text/html|text/plain" {
+		t.Errorf("readRequest() Accept values = %q", got)
+	}
+	if string(req.Body) != "ok" {
+		t.Errorf("readRequest() body = %q, want ok", req.Body)
+	}
+
+	var buf bytes.Buffer
+	req.Headers.Write(&buf)
+	want := "Accept: text/html\r\nAccept: text/plain\r\nVia: 1.0 first\r\nContent-Length: 2\r\n"
+	if buf.String() != want {
+		t.Errorf("Headers.Write() = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestReadRequest_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		limits   requestLimits
+		wantCode int
+		wantErr  error
+	}{
+		{
+			name:     "Header section too large",
+			input:    "GET / HTTP/1.1\r\nX-Big: " + strings.Repeat("a", 128) + "\r\n\r\n",
+			limits:   requestLimits{maxHeaderBytes: 64, maxBodyBytes: 1024},
+			wantCode: 431,
+		},
+		{
+			name:     "Body too large",
+			input:    "POST / HTTP/1.1\r\nContent-Length: 2048\r\n\r\n",
+			limits:   requestLimits{maxHeaderBytes: 1024, maxBodyBytes: 1024},
+			wantCode: 413,
+		},
+		{
+			name:     "Invalid Content-Length",
+			input:    "POST / HTTP/1.1\r\nContent-Length: ten\r\n\r\n",
+			limits:   testLimits(),
+			wantCode: 400,
+		},
+		{
+			name:     "Negative Content-Length",
+			input:    "POST / HTTP/1.1\r\nContent-Length: -1\r\n\r\n",
+			limits:   testLimits(),
+			wantCode: 400,
+		},
+		{
+			name:     "Conflicting Content-Length",
+			input:    "POST / HTTP/1.1\r\nContent-Length: 3\r\nContent-Length: 4\r\n\r\nabcd",
+			limits:   testLimits(),
+			wantCode: 400,
+		},
+		{
+			name:     "Transfer-Encoding split across lines",
+			input:    "POST / HTTP/1.1\r\nTransfer-Encoding: gzip\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n",
+			limits:   testLimits(),
+			wantCode: 501,
+		},
+		{
+			name:     "Short request line",
+			input:    "GET /\r\n\r\n",
+			limits:   testLimits(),
+			wantCode: 400,
+		},
+		{
+			name:     "Invalid escape in path",
+			input:    "GET /echo/%zz HTTP/1.1\r\n\r\n",
+			limits:   testLimits(),
+			wantCode: 400,
+		},
+		{
+			name:     "Truncated escape in query",
+			input:    "GET /files/a.txt?x=%4 HTTP/1.1\r\n\r\n",
+			limits:   testLimits(),
+			wantCode: 400,
+		},
+		{
+			name:     "Relative target",
+			input:    "GET files/a.txt HTTP/1.1\r\n\r\n",
+			limits:   testLimits(),
+			wantCode: 400,
+		},
+		{
+			name:    "Truncated headers",
+			input:   "GET / HTTP/1.1\r\nHost: local",
+			limits:  testLimits(),
+			wantErr: io.ErrUnexpectedEOF,
+		},
+		{
+			name:    "Truncated body",
+			input:   "POST / HTTP/1.1\r\nContent-Length: 10\r\n\r\nabc",
+			limits:  testLimits(),
+			wantErr: io.ErrUnexpectedEOF,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readRequest(bufio.NewReader(strings.NewReader(tt.input)), tt.limits)
+			if err == nil {
+				t.Fatal("readRequest() error = nil, want error")
+			}
+
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("readRequest() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+
+			var se *statusError
+			if !errors.As(err, &se) {
+				t.Fatalf("readRequest() error = %v, want *statusError", err)
+			}
+			if se.code != tt.wantCode {
+				t.Errorf("readRequest() status = %d, want %d", se.code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestServer_RequestLimits_Defaults(t *testing.T) {
+	s := &server{}
+	limits := s.requestLimits()
+	if limits.maxHeaderBytes != defaultMaxHeaderBytes {
+		t.Errorf("requestLimits() maxHeaderBytes = %d, want %d", limits.maxHeaderBytes, defaultMaxHeaderBytes)
+	}
+	if limits.maxBodyBytes != defaultMaxBodyBytes {
+		t.Errorf("requestLimits() maxBodyBytes = %d, want %d", limits.maxBodyBytes, defaultMaxBodyBytes)
+	}
+
+	s = &server{maxHeaderBytes: 10, maxBodyBytes: 20}
+	limits = s.requestLimits()
+	if limits.maxHeaderBytes != 10 || limits.maxBodyBytes != 20 {
+		t.Errorf("requestLimits() = %+v, want configured values", limits)
+	}
+}
+
+func BenchmarkReadRequest(b *testing.B) {
+	raw := []byte("GET /test HTTP/1.1\r\nHost: localhost\r\nUser-Agent: test\r\n\r\n")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		readRequest(bufio.NewReader(bytes.NewReader(raw)), testLimits())
+	}
+}
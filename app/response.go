@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// responseBufferSize is how much body a response holds back before sending
+// its header. A handler that writes less than this and returns gets a
+// Content-Length; anything longer is sent chunked.
+const responseBufferSize = 4096
+
+var (
+	ErrBodyNotAllowed = errors.New("response status does not allow a body")
+	ErrContentLength  = errors.New("wrote more than the declared Content-Length")
+)
+
+// ResponseWriter is used by a handler to construct a response. Headers must
+// be set before the first call to WriteHeader or Write; after that, changes
+// to Header() have no effect other than setting declared trailers.
+type ResponseWriter interface {
+	// Header returns the headers that will be sent by WriteHeader.
+	Header() *Headers
+	// WriteHeader sets the status code. Only the first call has an effect.
+	WriteHeader(code int)
+	// Write writes body bytes, calling WriteHeader(http.StatusOK) first if
+	// needed.
+	Write(b []byte) (int, error)
+	// Flush sends the header and any buffered body to the client.
+	Flush() error
+}
+
+// response is the ResponseWriter handed to handlers. It decides the body
+// framing when the header is sent: a Content-Length set by the handler is
+// used as-is, otherwise a body that fits in the buffer gets one computed for
+// it and a longer body is sent chunked. HTTP/1.0 clients do not understand
+// chunked framing, so their unknown-length bodies are delimited by closing
+// the connection instead.
+//
+// Trailers are declared by listing their names in the Trailer header before
+// the header is sent; their values are taken from Header() when the handler
+// returns, so they can be computed while the body is streamed.
+type response struct {
+	bw  *bufio.Writer
+	req *Request
+
+	header      *Headers
+	status      int
+	wroteHeader bool // status has been decided
+	headerSent  bool // status line and headers have been written to bw
+
+	contentLength int64 // -1 when unknown
+	written       int64
+	pending       []byte // body held back until the header is sent
+	chunked       *chunkedWriter
+	trailers      []string
+
+	// noBody is set for responses to HEAD: body bytes are counted so that
+	// Content-Length matches what GET would send, but never written.
+	// Handlers that set Content-Length themselves may skip the body.
+	noBody bool
+
+	// closeAfter is set when the connection must be closed once this
+	// response has been sent.
+	closeAfter bool
+
+	// closing is closed when the server starts shutting down; a header
+	// sent after that asks the client to close the connection.
+	closing <-chan struct{}
+}
+
+func newResponse(w io.Writer, req *Request) *response {
+	bw, ok := w.(*bufio.Writer)
+	if !ok {
+		bw = bufio.NewWriter(w)
+	}
+
+	var header *Headers
+	if req != nil {
+		header = NewResponseHeaders(req.Headers)
+	} else {
+		header = NewHeaders()
+	}
+
+	return &response{
+		bw:            bw,
+		req:           req,
+		header:        header,
+		contentLength: -1,
+		noBody:        req != nil && req.Method == http.MethodHead,
+		closeAfter:    req != nil && !req.wantsKeepAlive(),
+	}
+}
+
+func (r *response) Header() *Headers {
+	return r.header
+}
+
+func (r *response) WriteHeader(code int) {
+	if r.wroteHeader {
+		return
+	}
+	r.wroteHeader = true
+	r.status = code
+
+	if cl, ok := r.header.Get(HeaderContentLength); ok {
+		n, err := strconv.ParseInt(cl, 10, 64)
+		if err == nil && n >= 0 {
+			r.contentLength = n
+		} else {
+			r.header.Del(HeaderContentLength)
+		}
+	}
+}
+
+func (r *response) Write(b []byte) (int, error) {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	if !bodyAllowed(r.status) {
+		return 0, ErrBodyNotAllowed
+	}
+	if r.contentLength >= 0 && r.written+int64(len(b)) > r.contentLength {
+		return 0, ErrContentLength
+	}
+	r.written += int64(len(b))
+
+	if r.noBody {
+		return len(b), nil
+	}
+
+	if !r.headerSent {
+		if r.contentLength < 0 && len(r.pending)+len(b) <= responseBufferSize {
+			r.pending = append(r.pending, b...)
+			return len(b), nil
+		}
+		if err := r.sendHeader(); err != nil {
+			return 0, err
+		}
+	}
+
+	return r.writeBody(b)
+}
+
+func (r *response) Flush() error {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+	if !r.headerSent {
+		if err := r.sendHeader(); err != nil {
+			return err
+		}
+	}
+	return r.bw.Flush()
+}
+
+// finish completes the response after the handler has returned: it sends
+// the header if the handler never did, terminates a chunked body and flushes
+// everything to the client.
+func (r *response) finish() error {
+	return r.complete(true)
+}
+
+// complete is finish with control over flushing. Without a flush the
+// response stays in the connection's buffer, to be written together with
+// the responses that follow it.
+func (r *response) complete(flush bool) error {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+
+	if !r.headerSent {
+		if r.contentLength < 0 && bodyAllowed(r.status) && !r.hasTrailers() {
+			r.contentLength = r.written
+			r.header.Set(HeaderContentLength, strconv.FormatInt(r.written, 10))
+		}
+		if err := r.sendHeader(); err != nil {
+			return err
+		}
+	}
+
+	if r.chunked != nil && !r.noBody {
+		trailers := NewHeaders()
+		for _, k := range r.trailers {
+			for _, v := range r.header.Values(k) {
+				trailers.Add(k, v)
+			}
+		}
+		if err := r.chunked.close(trailers); err != nil {
+			return err
+		}
+	}
+
+	if flush {
+		if err := r.bw.Flush(); err != nil {
+			return fmt.Errorf("failed to flush response: %w", err)
+		}
+	}
+
+	if r.contentLength >= 0 && r.written < r.contentLength && !r.noBody {
+		return fmt.Errorf("handler wrote %d bytes, declared Content-Length %d", r.written, r.contentLength)
+	}
+	return nil
+}
+
+// sendHeader writes the status line and headers, choosing the body framing,
+// followed by any body held back so far.
+func (r *response) sendHeader() error {
+	r.headerSent = true
+
+	if r.header.hasToken(HeaderConnection, ConnectionClose) {
+		r.closeAfter = true
+	}
+	select {
+	case <-r.closing:
+		r.closeAfter = true
+	default:
+	}
+	if r.req != nil && r.req.body != nil && !r.req.body.started {
+		// The client is waiting for 100 Continue and may or may not send
+		// the body anyway, so the connection cannot be reused.
+		r.closeAfter = true
+	}
+
+	trailers := r.declaredTrailers()
+	r.header.Del(HeaderTrailer)
+
+	switch {
+	case !bodyAllowed(r.status):
+		r.header.Del(HeaderContentLength)
+		r.header.Del(HeaderTransferEncoding)
+	case r.contentLength >= 0:
+		r.header.Del(HeaderTransferEncoding)
+	case r.req != nil && r.req.Version == "HTTP/1.0":
+		// The body ends when the connection does.
+		r.closeAfter = true
+		r.header.Del(HeaderTransferEncoding)
+	default:
+		r.chunked = &chunkedWriter{w: r.bw}
+		r.trailers = trailers
+		r.header.Set(HeaderTransferEncoding, TransferEncodingChunked)
+		if len(trailers) > 0 {
+			r.header.Set(HeaderTrailer, strings.Join(trailers, ", "))
+		}
+	}
+
+	if r.closeAfter {
+		r.header.Set(HeaderConnection, ConnectionClose)
+	} else if _, hasConnection := r.header.Get(HeaderConnection); !hasConnection {
+		// Set Connection header to keep-alive by default if not already set
+		// This allows clients to see that the server supports persistent connections
+		r.header.Set(HeaderConnection, ConnectionKeepAlive)
+	}
+
+	header := r.header
+	if len(trailers) > 0 {
+		// Trailer fields are sent after the body instead.
+		header = header.Clone()
+		for _, k := range trailers {
+			header.Del(k)
+		}
+	}
+	if _, err := fmt.Fprintf(r.bw, "HTTP/1.1 %d %s\r\n", r.status, http.StatusText(r.status)); err != nil {
+		return fmt.Errorf("failed to write response header: %w", err)
+	}
+	if err := header.Write(r.bw); err != nil {
+		return fmt.Errorf("failed to write response header: %w", err)
+	}
+	if _, err := io.WriteString(r.bw, "\r\n"); err != nil {
+		return fmt.Errorf("failed to write response header: %w", err)
+	}
+
+	pending := r.pending
+	r.pending = nil
+	if _, err := r.writeBody(pending); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *response) writeBody(b []byte) (int, error) {
+	if len(b) == 0 || r.noBody {
+		return len(b), nil
+	}
+	if r.chunked != nil {
+		return r.chunked.Write(b)
+	}
+	n, err := r.bw.Write(b)
+	if err != nil {
+		return n, fmt.Errorf("failed to write response body: %w", err)
+	}
+	return n, nil
+}
+
+func (r *response) hasTrailers() bool {
+	_, ok := r.header.Get(HeaderTrailer)
+	return ok
+}
+
+// declaredTrailers returns the canonical field names listed in the Trailer
+// header.
+func (r *response) declaredTrailers() []string {
+	v, ok := r.header.Get(HeaderTrailer)
+	if !ok {
+		return nil
+	}
+
+	var names []string
+	for _, name := range strings.Split(v, ",") {
+		name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+		if name != "" && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// bodyAllowed reports whether a response with the given status may include
+// a body (RFC 9110 section 6.4.1).
+func bodyAllowed(code int) bool {
+	switch {
+	case code >= 100 && code <= 199:
+		return false
+	case code == http.StatusNoContent, code == http.StatusNotModified:
+		return false
+	}
+	return true
+}
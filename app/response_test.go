@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// readTestResponse parses raw with the standard library so that framing
+// mistakes show up as parse errors.
+func readTestResponse(t *testing.T, raw []byte, method string) (*http.Response, []byte) {
+	t.Helper()
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(raw)), &http.Request{Method: method})
+	if err != nil {
+		t.Fatalf("Failed to parse response %q: %v", raw, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read response body: %v", err)
+	}
+	return resp, body
+}
+
+func TestResponse_Framing(t *testing.T) {
+	large := strings.Repeat("x", 3*responseBufferSize)
+
+	tests := []struct {
+		name        string
+		handler     func(w ResponseWriter)
+		wantStatus  int
+		wantBody    string
+		wantLength  int64
+		wantChunked bool
+	}{
+		{
+			name:       "No writes",
+			handler:    func(w ResponseWriter) {},
+			wantStatus: 200,
+			wantLength: 0,
+		},
+		{
+			name: "Small body gets Content-Length",
+			handler: func(w ResponseWriter) {
+				w.Write([]byte("hello "))
+				w.Write([]byte("world"))
+			},
+			wantStatus: 200,
+			wantBody:   "hello world",
+			wantLength: 11,
+		},
+		{
+			name: "Large body is chunked",
+			handler: func(w ResponseWriter) {
+				for i := 0; i < len(large); i += 1000 {
+					w.Write([]byte(large[i:min(i+1000, len(large))]))
+				}
+			},
+			wantStatus:  200,
+			wantBody:    large,
+			wantLength:  -1,
+			wantChunked: true,
+		},
+		{
+			name: "Declared Content-Length is streamed",
+			handler: func(w ResponseWriter) {
+				w.Header().Set(HeaderContentLength, "12288")
+				w.WriteHeader(http.StatusAccepted)
+				w.Write([]byte(large))
+			},
+			wantStatus: 202,
+			wantBody:   large,
+			wantLength: int64(len(large)),
+		},
+		{
+			name: "Flush before finishing switches to chunked",
+			handler: func(w ResponseWriter) {
+				w.Write([]byte("part1"))
+				w.Flush()
+				w.Write([]byte("part2"))
+			},
+			wantStatus:  200,
+			wantBody:    "part1part2",
+			wantLength:  -1,
+			wantChunked: true,
+		},
+		{
+			name: "Header changes after write are ignored",
+			handler: func(w ResponseWriter) {
+				w.WriteHeader(http.StatusTeapot)
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte("short"))
+			},
+			wantStatus: 418,
+			wantBody:   "short",
+			wantLength: 5,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			resp := newResponse(&buf, createTestRequest("GET", "/", "HTTP/1.1", nil, nil))
+			tt.handler(resp)
+			if err := resp.finish(); err != nil {
+				t.Fatalf("finish() error = %v", err)
+			}
+
+			got, body := readTestResponse(t, buf.Bytes(), "GET")
+			if got.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", got.StatusCode, tt.wantStatus)
+			}
+			if string(body) != tt.wantBody {
+				t.Errorf("body length = %d, want %d", len(body), len(tt.wantBody))
+			}
+			if got.ContentLength != tt.wantLength {
+				t.Errorf("ContentLength = %d, want %d", got.ContentLength, tt.wantLength)
+			}
+			chunked := len(got.TransferEncoding) > 0 && got.TransferEncoding[0] == "chunked"
+			if chunked != tt.wantChunked {
+				t.Errorf("chunked = %v, want %v", chunked, tt.wantChunked)
+			}
+		})
+	}
+}
+
+func TestResponse_Trailers(t *testing.T) {
+	var buf bytes.Buffer
+	resp := newResponse(&buf, createTestRequest("GET", "/", "HTTP/1.1", nil, nil))
+	resp.Header().Set(HeaderTrailer, "x-checksum")
+
+	// Short enough to fit in the buffer, but trailers still force chunking.
+	h := sha256.New()
+	for _, part := range []string{"streamed ", "content"} {
+		resp.Write([]byte(part))
+		h.Write([]byte(part))
+	}
+	resp.Header().Set("X-Checksum", hex.EncodeToString(h.Sum(nil)))
+
+	if err := resp.finish(); err != nil {
+		t.Fatalf("finish() error = %v", err)
+	}
+
+	got, body := readTestResponse(t, buf.Bytes(), "GET")
+	if string(body) != "streamed content" {
+		t.Errorf("body = %q, want %q", body, "streamed content")
+	}
+	if got.Header.Get("X-Checksum") != "" {
+		t.Error("trailer should not be sent in the header section")
+	}
+	sum := sha256.Sum256([]byte("streamed content"))
+	if v := got.Trailer.Get("X-Checksum"); v != hex.EncodeToString(sum[:]) {
+		t.Errorf("trailer X-Checksum = %q, want %q", v, hex.EncodeToString(sum[:]))
+	}
+}
+
+func TestResponse_HTTP10(t *testing.T) {
+	large := strings.Repeat("y", 2*responseBufferSize)
+
+	var buf bytes.Buffer
+	req := createTestRequest("GET", "/", "HTTP/1.0", map[string]string{
+		"Connection": "keep-alive",
+	}, nil)
+	resp := newResponse(&buf, req)
+	resp.Header().Set(HeaderTrailer, "X-Checksum")
+	resp.Write([]byte(large))
+	resp.Header().Set("X-Checksum", "abc")
+	if err := resp.finish(); err != nil {
+		t.Fatalf("finish() error = %v", err)
+	}
+
+	if !resp.closeAfter {
+		t.Error("closeAfter = false, want true for close-delimited body")
+	}
+
+	raw := buf.String()
+	if strings.Contains(raw, HeaderTransferEncoding) || strings.Contains(raw, HeaderContentLength) {
+		t.Errorf("HTTP/1.0 response should be close-delimited, got headers:\n%s", raw[:strings.Index(raw, "\r\n\r\n")])
+	}
+	if !strings.Contains(raw, "Connection: close") {
+		t.Error("HTTP/1.0 response should send Connection: close")
+	}
+	if !strings.HasSuffix(raw, "\r\n\r\n"+large) {
+		t.Error("HTTP/1.0 response body should follow the header unframed")
+	}
+}
+
+func TestResponse_KeepAlive(t *testing.T) {
+	tests := []struct {
+		name      string
+		version   string
+		headers   map[string]string
+		set       string
+		wantClose bool
+	}{
+		{name: "HTTP/1.1 default", version: "HTTP/1.1", wantClose: false},
+		{name: "HTTP/1.1 close", version: "HTTP/1.1", headers: map[string]string{"Connection": "close"}, wantClose: true},
+		{name: "HTTP/1.0 default", version: "HTTP/1.0", wantClose: true},
+		{name: "HTTP/1.0 keep-alive", version: "HTTP/1.0", headers: map[string]string{"Connection": "keep-alive"}, wantClose: false},
+		{name: "Handler closes", version: "HTTP/1.1", set: "close", wantClose: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			resp := newResponse(&buf, createTestRequest("GET", "/", tt.version, tt.headers, nil))
+			if tt.set != "" {
+				resp.Header().Set(HeaderConnection, tt.set)
+			}
+			if err := httpResponse(resp, 200, "ok"); err != nil {
+				t.Fatalf("httpResponse() error = %v", err)
+			}
+			if err := resp.finish(); err != nil {
+				t.Fatalf("finish() error = %v", err)
+			}
+
+			if resp.closeAfter != tt.wantClose {
+				t.Errorf("closeAfter = %v, want %v", resp.closeAfter, tt.wantClose)
+			}
+			if got := strings.Contains(buf.String(), "Connection: close"); got != tt.wantClose {
+				t.Errorf("Connection: close sent = %v, want %v", got, tt.wantClose)
+			}
+		})
+	}
+}
+
+func TestResponse_NoBodyStatus(t *testing.T) {
+	var buf bytes.Buffer
+	resp := newResponse(&buf, nil)
+	resp.WriteHeader(http.StatusNoContent)
+
+	if _, err := resp.Write([]byte("nope")); err != ErrBodyNotAllowed {
+		t.Errorf("Write() error = %v, want ErrBodyNotAllowed", err)
+	}
+	if err := resp.finish(); err != nil {
+		t.Fatalf("finish() error = %v", err)
+	}
+
+	raw := buf.String()
+	if !strings.HasPrefix(raw, "HTTP/1.1 204 No Content\r\n") {
+		t.Errorf("response = %q, want 204 status line", raw)
+	}
+	if strings.Contains(raw, HeaderContentLength) || strings.Contains(raw, HeaderTransferEncoding) {
+		t.Errorf("response = %q, want no framing headers", raw)
+	}
+}
+
+func TestResponse_ContentLengthMismatch(t *testing.T) {
+	var buf bytes.Buffer
+	resp := newResponse(&buf, nil)
+	resp.Header().Set(HeaderContentLength, "4")
+
+	if _, err := resp.Write([]byte("too long")); err != ErrContentLength {
+		t.Errorf("Write() error = %v, want ErrContentLength", err)
+	}
+	if _, err := resp.Write([]byte("ab")); err != nil {
+		t.Errorf("Write() error = %v", err)
+	}
+	if err := resp.finish(); err == nil {
+		t.Error("finish() should fail when fewer bytes than Content-Length were written")
+	}
+}
+
+func BenchmarkResponse_Chunked(b *testing.B) {
+	chunk := bytes.Repeat([]byte("a"), 32*1024)
+
+	b.ResetTimer()
+	b.SetBytes(int64(len(chunk)) * 8)
+	for i := 0; i < b.N; i++ {
+		resp := newResponse(io.Discard, nil)
+		for j := 0; j < 8; j++ {
+			resp.Write(chunk)
+		}
+		resp.finish()
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A route pattern is a slash-separated path whose segments are either
+// literal, a named parameter "{name}" matching exactly one non-empty
+// segment, or a trailing catch-all "{name...}" matching the rest of the path.
+//
+// Patterns match on segment boundaries as a prefix: "/files" matches
+// "/files" and "/files/a.txt" but not "/filesXYZ". Ending a pattern with
+// "{$}" makes it exact, so "/{$}" matches only "/" and "/api/{$}" matches
+// "/api" and "/api/" but nothing below them.
+//
+// When several patterns match, the most specific wins: literal segments are
+// preferred over parameters, parameters over catch-alls, and longer matches
+// over shorter prefixes, regardless of registration order.
+
+const (
+	exactMarker    = "{$}"
+	wildcardSuffix = "...}"
+)
+
+// node is a node of the routing trie. Each edge is a path segment.
+type node struct {
+	static   map[string]*node
+	param    *node // {name}
+	wildcard *node // {name...}, always a leaf
+
+	// exact holds routes that match only when the path ends at this node,
+	// prefix holds routes that also match any path below it. Both are keyed
+	// by method.
+	exact  map[string]*route
+	prefix map[string]*route
+}
+
+type route struct {
+	match
+	// params names the parameter captured at each parameter position of
+	// the pattern, in order.
+	params []string
+}
+
+func newNode() *node {
+	return &node{
+		static: make(map[string]*node),
+		exact:  make(map[string]*route),
+		prefix: make(map[string]*route),
+	}
+}
+
+// splitPath splits a path into its segments. "/" has no segments; a trailing
+// slash yields a final empty segment.
+func splitPath(p string) []string {
+	p = strings.TrimPrefix(p, "/")
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+// add registers m in the trie rooted at n. A later registration of the same
+// method and pattern replaces the earlier one.
+func (n *node) add(m match) error {
+	segs := splitPath(m.pattern)
+	exact := false
+	if len(segs) > 0 && segs[len(segs)-1] == exactMarker {
+		exact = true
+		segs = segs[:len(segs)-1]
+	}
+	// "/files/" registers the same prefix route as "/files".
+	if len(segs) > 0 && segs[len(segs)-1] == "" {
+		segs = segs[:len(segs)-1]
+	}
+
+	r := &route{match: m}
+	cur := n
+	for i, seg := range segs {
+		name, isParam := strings.CutPrefix(seg, "{")
+		if !isParam {
+			child, ok := cur.static[seg]
+			if !ok {
+				child = newNode()
+				cur.static[seg] = child
+			}
+			cur = child
+			continue
+		}
+
+		if name, ok := strings.CutSuffix(name, wildcardSuffix); ok {
+			if i != len(segs)-1 || exact {
+				return fmt.Errorf("invalid pattern %q: %s must be the last segment", m.pattern, seg)
+			}
+			if name == "" {
+				return fmt.Errorf("invalid pattern %q: unnamed wildcard", m.pattern)
+			}
+			if cur.wildcard == nil {
+				cur.wildcard = newNode()
+			}
+			r.params = append(r.params, name)
+			cur = cur.wildcard
+			exact = true
+			continue
+		}
+
+		name, ok := strings.CutSuffix(name, "}")
+		if !ok || name == "" || strings.ContainsAny(name, "{}") {
+			return fmt.Errorf("invalid pattern %q: bad segment %q", m.pattern, seg)
+		}
+		if cur.param == nil {
+			cur.param = newNode()
+		}
+		r.params = append(r.params, name)
+		cur = cur.param
+	}
+
+	if exact {
+		cur.exact[m.method] = r
+	} else {
+		cur.prefix[m.method] = r
+	}
+	return nil
+}
+
+// lookup finds the most specific route for method and path and returns it
+// together with the values of its path parameters.
+func (n *node) lookup(method, path string) (*route, map[string]string) {
+	var values []string
+	r, values := n.find(method, splitPath(path), values)
+	if r == nil {
+		return nil, nil
+	}
+
+	params := make(map[string]string, len(r.params))
+	for i, name := range r.params {
+		params[name] = values[i]
+	}
+	return r, params
+}
+
+func (n *node) find(method string, segs []string, values []string) (*route, []string) {
+	if len(segs) == 0 || (len(segs) == 1 && segs[0] == "") {
+		if r, ok := n.exact[method]; ok {
+			return r, values
+		}
+	}
+
+	if len(segs) > 0 {
+		if child, ok := n.static[segs[0]]; ok {
+			if r, v := child.find(method, segs[1:], values); r != nil {
+				return r, v
+			}
+		}
+		if n.param != nil && segs[0] != "" {
+			if r, v := n.param.find(method, segs[1:], append(values, segs[0])); r != nil {
+				return r, v
+			}
+		}
+		if n.wildcard != nil {
+			if r, ok := n.wildcard.exact[method]; ok {
+				return r, append(values, strings.Join(segs, "/"))
+			}
+		}
+	}
+
+	if r, ok := n.prefix[method]; ok {
+		return r, values
+	}
+	return nil, values
+}
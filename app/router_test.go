@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNode_Lookup(t *testing.T) {
+	root := newNode()
+	patterns := []struct {
+		method  string
+		pattern string
+	}{
+		{"GET", "/{$}"},
+		{"GET", "/files/{name}"},
+		{"GET", "/files/special"},
+		{"GET", "/static/{path...}"},
+		{"GET", "/users/{id}/posts/{post}"},
+		{"GET", "/echo"},
+		{"POST", "/echo"},
+		{"GET", "/api/{$}"},
+	}
+	for _, p := range patterns {
+		err := root.add(match{method: p.method, pattern: p.pattern, handler: nil})
+		if err != nil {
+			t.Fatalf("add(%q) error = %v", p.pattern, err)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		wantPattern string
+		wantParams  map[string]string
+	}{
+		{name: "Root exact", method: "GET", path: "/", wantPattern: "/{$}"},
+		{name: "Root does not swallow", method: "GET", path: "/anything"},
+		{name: "Named parameter", method: "GET", path: "/files/a.txt", wantPattern: "/files/{name}", wantParams: map[string]string{"name": "a.txt"}},
+		{name: "Literal beats parameter", method: "GET", path: "/files/special", wantPattern: "/files/special"},
+		{name: "Parameter needs a segment", method: "GET", path: "/files/"},
+		{name: "No partial segment match", method: "GET", path: "/filesXYZ"},
+		{name: "Parameter routes are prefixes", method: "GET", path: "/files/a.txt/more", wantPattern: "/files/{name}", wantParams: map[string]string{"name": "a.txt"}},
+		{name: "Catch-all", method: "GET", path: "/static/css/site.css", wantPattern: "/static/{path...}", wantParams: map[string]string{"path": "css/site.css"}},
+		{name: "Catch-all with trailing slash", method: "GET", path: "/static/", wantPattern: "/static/{path...}", wantParams: map[string]string{"path": ""}},
+		{name: "Catch-all needs the slash", method: "GET", path: "/static"},
+		{name: "Multiple parameters", method: "GET", path: "/users/42/posts/7", wantPattern: "/users/{id}/posts/{post}", wantParams: map[string]string{"id": "42", "post": "7"}},
+		{name: "Prefix route", method: "GET", path: "/echo/hello", wantPattern: "/echo"},
+		{name: "Method specific", method: "POST", path: "/echo/hello", wantPattern: "/echo"},
+		{name: "Unknown method", method: "PUT", path: "/echo/hello"},
+		{name: "Exact route", method: "GET", path: "/api", wantPattern: "/api/{$}"},
+		{name: "Exact route with trailing slash", method: "GET", path: "/api/", wantPattern: "/api/{$}"},
+		{name: "Exact route is not a prefix", method: "GET", path: "/api/v1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, params := root.lookup(tt.method, tt.path)
+			if tt.wantPattern == "" {
+				if r != nil {
+					t.Errorf("lookup(%q) = %q, want no match", tt.path, r.pattern)
+				}
+				return
+			}
+			if r == nil {
+				t.Fatalf("lookup(%q) = no match, want %q", tt.path, tt.wantPattern)
+			}
+			if r.pattern != tt.wantPattern {
+				t.Errorf("lookup(%q) = %q, want %q", tt.path, r.pattern, tt.wantPattern)
+			}
+			for k, want := range tt.wantParams {
+				if params[k] != want {
+					t.Errorf("lookup(%q) param %s = %q, want %q", tt.path, k, params[k], want)
+				}
+			}
+		})
+	}
+}
+
+func TestNode_Add_InvalidPatterns(t *testing.T) {
+	patterns := []string{
+		"/static/{path...}/more",
+		"/static/{...}",
+		"/files/{}",
+		"/files/{name",
+		"/files/{path...}/{$}",
+	}
+
+	for _, p := range patterns {
+		t.Run(p, func(t *testing.T) {
+			if err := newNode().add(match{method: "GET", pattern: p}); err == nil {
+				t.Errorf("add(%q) error = nil, want error", p)
+			}
+		})
+	}
+}
+
+func TestServer_Route_PathValues(t *testing.T) {
+	server := createTestServer(t)
+
+	var got string
+	server.Register("GET", "/files/{name...}", func(ctx context.Context, req *Request, w ResponseWriter) error {
+		got = req.PathValue("name")
+		return nil
+	})
+	// Registered after the more specific route to show that order does not matter.
+	server.Register("GET", "/", func(ctx context.Context, req *Request, w ResponseWriter) error {
+		got = "root"
+		return nil
+	})
+
+	var buf bytes.Buffer
+	req := createTestRequest("GET", "/files/dir/a.txt", "HTTP/1.1", nil, nil)
+	if err := routeRequest(context.Background(), server, req, &buf); err != nil {
+		t.Fatalf("Route() error = %v", err)
+	}
+	if got != "dir/a.txt" {
+		t.Errorf("PathValue(name) = %q, want dir/a.txt", got)
+	}
+}
+
+func TestServer_Register_InvalidPattern(t *testing.T) {
+	server := createTestServer(t)
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Register() should panic on an invalid pattern")
+		}
+	}()
+	server.Register("GET", "/{path...}/x", nil)
+}
+
+func BenchmarkNode_Lookup(b *testing.B) {
+	root := newNode()
+	for _, p := range []string{"/{$}", "/echo/{text...}", "/user-agent", "/files/{name...}", "/api/v1/users/{id}"} {
+		root.add(match{method: "GET", pattern: p})
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		root.lookup("GET", "/api/v1/users/123")
+	}
+}
+
+func TestServer_Route_MethodHandling(t *testing.T) {
+	server := createTestServer(t)
+	server.Register("GET", "/files/{name...}", server.filesGet)
+	server.Register("POST", "/files/{name...}", server.filesPost)
+	server.Register("GET", "/echo/{text...}", server.echoGet)
+
+	if err := os.WriteFile(filepath.Join(server.dir, "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		wantStatus int
+		wantAllow  string
+		wantLength string
+		wantBody   string
+	}{
+		{
+			name:       "Method not allowed",
+			method:     "DELETE",
+			target:     "/files/a.txt",
+			wantStatus: 405,
+			wantAllow:  "GET, HEAD, OPTIONS, POST",
+		},
+		{
+			name:       "Method not allowed on GET-only route",
+			method:     "POST",
+			target:     "/echo/hi",
+			wantStatus: 405,
+			wantAllow:  "GET, HEAD, OPTIONS",
+		},
+		{
+			name:       "Automatic OPTIONS",
+			method:     "OPTIONS",
+			target:     "/files/a.txt",
+			wantStatus: 204,
+			wantAllow:  "GET, HEAD, OPTIONS, POST",
+		},
+		{
+			name:       "OPTIONS for the whole server",
+			method:     "OPTIONS",
+			target:     "*",
+			wantStatus: 204,
+			wantAllow:  "GET, HEAD, OPTIONS, POST",
+		},
+		{
+			name:       "OPTIONS on unknown path",
+			method:     "OPTIONS",
+			target:     "/nowhere",
+			wantStatus: 404,
+		},
+		{
+			name:       "HEAD served by GET",
+			method:     "HEAD",
+			target:     "/files/a.txt",
+			wantStatus: 200,
+			wantLength: "5",
+		},
+		{
+			name:       "HEAD with computed length",
+			method:     "HEAD",
+			target:     "/echo/hello",
+			wantStatus: 200,
+			wantLength: "5",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			req := createTestRequest(tt.method, tt.target, "HTTP/1.1", nil, nil)
+			if err := routeRequest(context.Background(), server, req, &buf); err != nil {
+				t.Fatalf("Route() error = %v", err)
+			}
+
+			statusCode, headers, body := parseHTTPResponse(buf.String())
+			if !strings.HasPrefix(buf.String(), fmt.Sprintf("HTTP/1.1 %d ", tt.wantStatus)) {
+				t.Errorf("Route() status = %d, want %d\n%s", statusCode, tt.wantStatus, buf.String())
+			}
+			if headers["Allow"] != tt.wantAllow {
+				t.Errorf("Route() Allow = %q, want %q", headers["Allow"], tt.wantAllow)
+			}
+			if tt.wantLength != "" && headers["Content-Length"] != tt.wantLength {
+				t.Errorf("Route() Content-Length = %q, want %q", headers["Content-Length"], tt.wantLength)
+			}
+			if body != tt.wantBody {
+				t.Errorf("Route() body = %q, want %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestServer_Route_RegisteredOptionsHandler(t *testing.T) {
+	server := createTestServer(t)
+	server.Register("GET", "/api", func(ctx context.Context, req *Request, w ResponseWriter) error {
+		return httpResponse(w, 200, "get")
+	})
+	server.Register("OPTIONS", "/api", func(ctx context.Context, req *Request, w ResponseWriter) error {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		return httpResponse(w, 200, "")
+	})
+
+	var buf bytes.Buffer
+	req := createTestRequest("OPTIONS", "/api", "HTTP/1.1", nil, nil)
+	if err := routeRequest(context.Background(), server, req, &buf); err != nil {
+		t.Fatalf("Route() error = %v", err)
+	}
+	if !strings.Contains(buf.String(), "Access-Control-Allow-Origin: *") {
+		t.Errorf("Route() should use the registered OPTIONS handler, got:\n%s", buf.String())
+	}
+}
@@ -1,27 +1,109 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"net/http"
 	"os"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 )
 
 type match struct {
 	method  string
-	prefix  string
+	pattern string
 	handler handleFunc
 }
+
 type server struct {
 	dir        string
 	routes     []match
+	tree       *node
+	middleware []Middleware
+	groups     []*group
 	listener   *net.TCPListener
 	shutdownCh <-chan os.Signal
+
+	// maxHeaderBytes and maxBodyBytes bound the size of a request's header
+	// section and body. Zero means the package defaults.
+	maxHeaderBytes int
+	maxBodyBytes   int64
+
+	// Connection timeouts. Zero means the package default and a negative
+	// duration disables the timeout.
+	//
+	// readHeaderTimeout bounds reading a request's line and headers,
+	// readTimeout reading its body and writeTimeout each write of the
+	// response. idleTimeout is how long a keep-alive connection waits for
+	// the next request, and handlerTimeout bounds the handler's context,
+	// which is also cancelled when the client disconnects.
+	readHeaderTimeout time.Duration
+	readTimeout       time.Duration
+	writeTimeout      time.Duration
+	idleTimeout       time.Duration
+	handlerTimeout    time.Duration
+
+	// maxPipelined caps how many responses to pipelined requests are held
+	// back before being flushed. Zero means defaultMaxPipelined.
+	maxPipelined int
+
+	// continueCheck may reject requests sent with Expect: 100-continue
+	// before their body is sent; see CheckContinue.
+	continueCheck func(ctx context.Context, req *Request) error
+
+	// lenient relaxes request validation for legacy clients; see
+	// requestLimits.
+	lenient bool
+
+	// maxConns caps the number of open connections and maxConnsPerIP the
+	// number from a single remote IP. Connections over either cap are
+	// answered with 503, unless queueConns is set, in which case the server
+	// stops accepting until a connection closes. maxRequestsPerConn closes
+	// keep-alive connections after that many requests. Zero means no limit.
+	maxConns           int
+	maxConnsPerIP      int
+	maxRequestsPerConn int
+	queueConns         bool
+	limitCounters      limitCounters
+
+	// metrics collects the counters served by metricsGet.
+	metrics metrics
+
+	// etagMode selects how file entity tags are generated: ETagModTime
+	// (the default when empty), ETagWeak or ETagHash.
+	etagMode string
+
+	// showHidden includes dotfiles, such as in-progress uploads, in
+	// directory listings.
+	showHidden bool
+
+	// accessLog, if set, receives one entry per request.
+	accessLog *accessLog
+
+	// shutdownTimeout bounds the graceful shutdown Start performs when it
+	// is stopped. Zero means defaultShutdownTimeout and a negative duration
+	// waits for in-flight requests indefinitely.
+	shutdownTimeout time.Duration
+
+	// mu guards the connection tracking used by Shutdown; see shutdown.go.
+	mu         sync.Mutex
+	conns      map[net.Conn]connState
+	connWG     sync.WaitGroup
+	inShutdown bool
+	closingCh  chan struct{}
+
+	// connSlotsCh and ipConns hold the state of the connection limits;
+	// see limits.go. ipConns is guarded by mu.
+	connSlotsCh chan struct{}
+	ipConns     map[string]int
 }
 
 func NewServer(dir string, listener *net.TCPListener, shutdownCh <-chan os.Signal) *server {
@@ -33,57 +115,168 @@ func NewServer(dir string, listener *net.TCPListener, shutdownCh <-chan os.Signa
 	return s
 }
 
-func (s *server) Register(method string, prefix string, handler handleFunc) {
-	s.routes = append(s.routes, match{method: method, prefix: prefix, handler: handler})
+// Register adds a handler for method and pattern. See router.go for the
+// pattern syntax. The route's middleware runs after any global middleware,
+// in the order given. It panics if the pattern is invalid.
+func (s *server) Register(method string, pattern string, handler handleFunc, mw ...Middleware) {
+	m := match{method: method, pattern: pattern, handler: chain(handler, mw...)}
+	if s.tree == nil {
+		s.tree = newNode()
+	}
+	if err := s.tree.add(m); err != nil {
+		panic(fmt.Sprintf("failed to register route: %v", err))
+	}
+	s.routes = append(s.routes, m)
 }
 
-func (s *server) Route(ctx context.Context, req *Request, w io.Writer) error {
-	for _, m := range s.routes {
-		if req.Method == m.method && strings.HasPrefix(req.Target, m.prefix) {
-			log.Printf("Matched method=%s prefix=%s", m.method, m.prefix)
-			return m.handler(ctx, req, w)
+// Route runs the global middleware and then dispatches req to the most
+// specific matching handler. A path that matches a route for another method
+// is answered with 405 and an Allow header, OPTIONS is answered from the
+// route table unless a handler is registered for it, and HEAD falls back to
+// the GET handler. Other unmatched paths under a group get the group's
+// not-found handler.
+func (s *server) Route(ctx context.Context, req *Request, w ResponseWriter) error {
+	return chain(s.dispatch, s.middleware...)(ctx, req, w)
+}
+
+func (s *server) dispatch(ctx context.Context, req *Request, w ResponseWriter) error {
+	if r, params := s.lookup(req.Method, req.Path); r != nil {
+		req.Pattern = r.pattern
+		req.pathValues = params
+		if req.expectContinue && s.continueCheck != nil {
+			if err := s.continueCheck(ctx, req); err != nil {
+				return rejectContinue(w, err)
+			}
+		}
+		return r.handler(ctx, req, w)
+	}
+
+	allowed := s.allowedMethods(req.Path)
+	if len(allowed) > 0 {
+		w.Header().Set(HeaderAllow, strings.Join(allowed, ", "))
+		if req.Method == http.MethodOptions {
+			return s.handleOptions(ctx, req, w)
 		}
+		return s.handleMethodNotAllowed(ctx, req, w)
+	}
+
+	if g := s.groupFor(req.Path); g != nil {
+		return g.handleNotFound(ctx, req, w)
 	}
-	log.Println("Did not match any route")
 	return s.handleNotFound(ctx, req, w)
 }
 
+// lookup finds the route for method and path. HEAD requests are served by
+// the GET route when there is no HEAD route; the response discards the body.
+func (s *server) lookup(method, path string) (*route, map[string]string) {
+	if s.tree == nil {
+		return nil, nil
+	}
+	r, params := s.tree.lookup(method, path)
+	if r == nil && method == http.MethodHead {
+		r, params = s.tree.lookup(http.MethodGet, path)
+	}
+	return r, params
+}
+
+// allowedMethods returns the sorted methods that have a route for path,
+// including the implied HEAD and OPTIONS, or nil if no route matches it.
+// The asterisk-form target "*" matches every route.
+func (s *server) allowedMethods(path string) []string {
+	var allowed []string
+	for _, m := range s.routes {
+		if slices.Contains(allowed, m.method) {
+			continue
+		}
+		if path == "*" {
+			allowed = append(allowed, m.method)
+		} else if r, _ := s.lookup(m.method, path); r != nil {
+			allowed = append(allowed, m.method)
+		}
+	}
+	if len(allowed) == 0 {
+		return nil
+	}
+
+	if slices.Contains(allowed, http.MethodGet) && !slices.Contains(allowed, http.MethodHead) {
+		allowed = append(allowed, http.MethodHead)
+	}
+	if !slices.Contains(allowed, http.MethodOptions) {
+		allowed = append(allowed, http.MethodOptions)
+	}
+	slices.Sort(allowed)
+	return allowed
+}
+
 func (s *server) Start(ctx context.Context) error {
-	// Set a short read timeout on the listener to make it non-blocking
+	// Shut down gracefully once the context is cancelled or a signal
+	// arrives. Start returns when the shutdown is complete.
+	shutdownDone := make(chan struct{})
 	go func(ctx context.Context) {
+		defer close(shutdownDone)
 		select {
 		case <-ctx.Done():
 		case <-s.shutdownCh:
+		case <-s.closing():
 		}
 		log.Println("Server is shutting down...")
+
+		sctx, cancel := s.shutdownContext()
+		defer cancel()
+		if aborted, err := s.Shutdown(sctx); err != nil {
+			log.Printf("Shutdown did not complete: %v, aborted %d connections", err, aborted)
+		}
 	}(ctx)
 
+	// Accept blocks until a connection arrives or Shutdown closes the
+	// listener. Other errors, such as running out of file descriptors, are
+	// retried with exponential backoff.
+	var backoff time.Duration
 	for {
-		select {
-		case <-ctx.Done():
+		// When queueing, wait for a free connection slot before accepting,
+		// leaving clients in the listen backlog meanwhile.
+		if s.queueConns && !s.acquireConnSlot(true) {
+			<-shutdownDone
 			return ctx.Err()
-		default:
 		}
 
-		// Set a short deadline to make Accept non-blocking
-		s.listener.SetDeadline(time.Now().Add(1 * time.Second))
-
 		conn, err := s.listener.Accept()
 		if err != nil {
-			if opErr, ok := err.(*net.OpError); ok && opErr.Timeout() {
-				// Timeout occurred, continue to check context
-				continue
+			if s.queueConns {
+				s.releaseConnSlot()
+			}
+			select {
+			case <-s.closing():
+				<-shutdownDone
+				return ctx.Err()
+			default:
 			}
 
 			if errors.Is(err, net.ErrClosed) {
 				return fmt.Errorf("failed to accept connection: %w", err)
 			}
 
-			log.Println("Error accepting connection: ", err.Error())
+			backoff = nextAcceptBackoff(backoff)
+			log.Printf("Error accepting connection: %v; retrying in %v", err, backoff)
+			select {
+			case <-time.After(backoff):
+			case <-s.closing():
+			}
 			continue
 		}
+		backoff = 0
+		s.metrics.connsAccepted.Add(1)
 
 		go func(conn net.Conn) {
+			release, rejected := s.admitConn(conn)
+			if rejected != nil {
+				if err := s.rejectConn(conn, rejected); err != nil {
+					log.Println("Error rejecting connection: ", err.Error())
+				}
+				return
+			}
+			defer release()
+
 			if err := s.handleConn(conn); err != nil {
 				log.Println("Error handling connection: ", err.Error())
 			}
@@ -91,61 +284,172 @@ func (s *server) Start(ctx context.Context) error {
 	}
 }
 
+const (
+	minAcceptBackoff = 5 * time.Millisecond
+	maxAcceptBackoff = time.Second
+)
+
+// nextAcceptBackoff doubles the delay before retrying a failed Accept,
+// starting at minAcceptBackoff and capped at maxAcceptBackoff.
+func nextAcceptBackoff(d time.Duration) time.Duration {
+	if d == 0 {
+		return minAcceptBackoff
+	}
+	return min(2*d, maxAcceptBackoff)
+}
+
+// defaultMaxPipelined is the default for server.maxPipelined.
+const defaultMaxPipelined = 16
+
+func (s *server) pipelineLimit() int {
+	if s.maxPipelined <= 0 {
+		return defaultMaxPipelined
+	}
+	return s.maxPipelined
+}
+
+// hasBufferedRequest reports whether br already holds the complete header
+// section of another request, so reading it will not block.
+func hasBufferedRequest(br *bufio.Reader) bool {
+	b, _ := br.Peek(br.Buffered())
+	b = bytes.TrimLeft(b, "\r\n")
+	return bytes.Contains(b, []byte("\n\r\n")) || bytes.Contains(b, []byte("\n\n"))
+}
+
 func (s *server) handleConn(conn net.Conn) error {
+	if !s.trackConn(conn) {
+		return conn.Close()
+	}
+	defer s.untrackConn(conn)
 	defer conn.Close()
 
 	log.Println("Handling new connection")
 
-	// Handle multiple requests on the same connection
-	for {
-		// Set a timeout for each request
-		if err := conn.SetDeadline(time.Now().Add(30 * time.Second)); err != nil {
-			log.Println("Error setting read deadline: ", err.Error())
-			break
-		}
+	t := s.timeouts()
+
+	// The reader outlives a single request so that bytes read past the end of
+	// one request are not lost.
+	counted := countingConn{Conn: conn, m: &s.metrics}
+	cr := newConnReader(counted)
+	br := bufio.NewReader(cr)
+	bw := bufio.NewWriter(deadlineWriter{conn: counted, timeout: t.write})
+	// Send any held back responses even if reading the next request fails.
+	defer bw.Flush()
+
+	// held counts responses written to bw but not yet flushed.
+	held := 0
+	// served counts the requests read from the connection.
+	served := 0
 
-		buf := make([]byte, 4096)
-		n, err := conn.Read(buf)
+	// Handle multiple requests on the same connection. Pipelined requests
+	// are read from br and answered one at a time, so responses are written
+	// in the order the requests arrived.
+	for {
+		req, err := s.readNextRequest(conn, br, s.requestLimits(), t)
 		if err != nil {
-			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			if errors.Is(err, errServerClosed) || s.shuttingDown() {
+				log.Println("Closing connection for shutdown")
+				break
+			}
+			if errors.Is(err, io.EOF) {
+				log.Println("Connection closed by client")
+				break
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
 				log.Println("Connection timeout, closing")
 				break
 			}
-			return fmt.Errorf("failed to read conn: %w", err)
-		}
-
-		b := buf[:n]
-		req := &Request{}
-		if err := req.From(b); err != nil {
+			var se *statusError
+			if errors.As(err, &se) {
+				log.Println("Rejecting request: ", se.Error())
+				s.metrics.observeRejected(se.code)
+				if s.accessLog != nil {
+					s.accessLog.log(accessEntry{
+						remoteAddr: conn.RemoteAddr().String(),
+						time:       time.Now(),
+						status:     se.code,
+						reused:     served,
+					})
+				}
+				resp := newResponse(bw, nil)
+				resp.Header().Set(HeaderConnection, ConnectionClose)
+				resp.Header().Set(HeaderContentType, ContentTypeTextPlain)
+				if err := httpResponse(resp, se.code, se.reason); err != nil {
+					return err
+				}
+				return resp.finish()
+			}
 			return fmt.Errorf("failed to read request: %w", err)
 		}
 
-		log.Printf("Request: %s", req)
-
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		start := time.Now()
+		var ctx context.Context
+		var cancel context.CancelFunc
+		if t.handler > 0 {
+			ctx, cancel = context.WithTimeout(context.Background(), t.handler)
+		} else {
+			ctx, cancel = context.WithCancel(context.Background())
+		}
+		if req.body != nil {
+			req.body.onStart = func() error {
+				if err := conn.SetReadDeadline(deadline(t.read)); err != nil {
+					return err
+				}
+				return writeContinue(bw)
+			}
+		} else {
+			// Nothing else reads from the connection until the handler
+			// returns, so watch it for the client going away.
+			cr.startBackgroundRead(cancel)
+		}
 
-		if err := s.Route(ctx, req, conn); err != nil {
-			cancel()
-			return fmt.Errorf("failed to handle request: %w", err)
+		resp := newResponse(bw, req)
+		resp.closing = s.closing()
+		if served++; s.maxRequestsPerConn > 0 && served >= s.maxRequestsPerConn && !resp.closeAfter {
+			resp.closeAfter = true
+			s.limitCounters.maxRequestsReached.Add(1)
 		}
+		err = s.Route(ctx, req, resp)
+		cr.abortPendingRead()
 		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to handle request: %w", err)
+		}
 
-		// Check if we should keep the connection alive
-		connectionHeader, _ := req.Headers.Get(HeaderConnection)
-		var keepAlive bool
+		if req.body != nil && req.body.started && !req.body.done {
+			// The client was told to send the body; skip what the handler
+			// left unread to reach the next request.
+			if _, err := io.Copy(io.Discard, req.body); err != nil {
+				resp.closeAfter = true
+			}
+		}
 
-		if req.Version == "HTTP/1.1" {
-			// HTTP/1.1 defaults to keep-alive unless explicitly closed
-			keepAlive = !strings.EqualFold(connectionHeader, ConnectionClose)
+		// When the client has already sent its next request, hold this
+		// response back so that answers to pipelined requests go out
+		// together, but never more than maxPipelined of them at once.
+		flush := resp.closeAfter || !hasBufferedRequest(br) || held+1 >= s.pipelineLimit()
+		if err := resp.complete(flush); err != nil {
+			return fmt.Errorf("failed to finish response: %w", err)
+		}
+		s.metrics.observeRequest(req.Method, req.Pattern, resp.status, time.Since(start), served > 1)
+		if s.accessLog != nil {
+			s.accessLog.log(newAccessEntry(conn, req, resp, start, served-1))
+		}
+		if flush {
+			held = 0
 		} else {
-			// HTTP/1.0 defaults to close unless explicitly keep-alive
-			keepAlive = strings.EqualFold(connectionHeader, ConnectionKeepAlive)
+			held++
 		}
 
-		if !keepAlive {
+		if resp.closeAfter {
 			log.Println("Connection marked for close")
 			break
 		}
+		if s.shuttingDown() {
+			log.Println("Closing connection for shutdown")
+			break
+		}
 
 		log.Println("Keeping connection alive for next request")
 	}
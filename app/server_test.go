@@ -1,11 +1,15 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"fmt"
 	"io"
 	"net"
+	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -39,7 +43,7 @@ func TestServer_Register(t *testing.T) {
 	server := createTestServer(t)
 
 	// Test registering a route
-	handler := func(ctx context.Context, req *Request, w io.Writer) error {
+	handler := func(ctx context.Context, req *Request, w ResponseWriter) error {
 		return nil
 	}
 
@@ -54,8 +58,8 @@ func TestServer_Register(t *testing.T) {
 		t.Errorf("Register() method = %v, want GET", route.method)
 	}
 
-	if route.prefix != "/test" {
-		t.Errorf("Register() prefix = %v, want /test", route.prefix)
+	if route.pattern != "/test" {
+		t.Errorf("Register() pattern = %v, want /test", route.pattern)
 	}
 
 	// Test registering multiple routes
@@ -72,7 +76,7 @@ func TestServer_Route(t *testing.T) {
 
 	// Register test handlers
 	handlerCalled := false
-	testHandler := func(ctx context.Context, req *Request, w io.Writer) error {
+	testHandler := func(ctx context.Context, req *Request, w ResponseWriter) error {
 		handlerCalled = true
 		w.Write([]byte("test response"))
 		return nil
@@ -82,10 +86,11 @@ func TestServer_Route(t *testing.T) {
 	server.Register("POST", "/api", testHandler)
 
 	tests := []struct {
-		name         string
-		request      *Request
-		wantHandler  bool
-		wantNotFound bool
+		name                 string
+		request              *Request
+		wantHandler          bool
+		wantNotFound         bool
+		wantMethodNotAllowed bool
 	}{
 		{
 			name:        "Match exact route",
@@ -108,9 +113,9 @@ func TestServer_Route(t *testing.T) {
 			wantNotFound: true,
 		},
 		{
-			name:         "Wrong method",
-			request:      createTestRequest("DELETE", "/test", "HTTP/1.1", nil, nil),
-			wantNotFound: true,
+			name:                 "Wrong method",
+			request:              createTestRequest("DELETE", "/test", "HTTP/1.1", nil, nil),
+			wantMethodNotAllowed: true,
 		},
 		{
 			name:         "Similar but not matching prefix",
@@ -125,7 +130,7 @@ func TestServer_Route(t *testing.T) {
 			var buf bytes.Buffer
 			ctx := context.Background()
 
-			err := server.Route(ctx, tt.request, &buf)
+			err := routeRequest(ctx, server, tt.request, &buf)
 			if err != nil {
 				t.Errorf("Route() error = %v", err)
 			}
@@ -134,7 +139,7 @@ func TestServer_Route(t *testing.T) {
 				t.Error("Route() should have called registered handler")
 			}
 
-			if tt.wantNotFound && handlerCalled {
+			if (tt.wantNotFound || tt.wantMethodNotAllowed) && handlerCalled {
 				t.Error("Route() should not have called handler for non-matching route")
 			}
 
@@ -145,6 +150,14 @@ func TestServer_Route(t *testing.T) {
 					t.Error("Route() should return 404 for non-matching route")
 				}
 			}
+			if tt.wantMethodNotAllowed {
+				if !strings.HasPrefix(response, "HTTP/1.1 405") {
+					t.Error("Route() should return 405 for a route registered with another method")
+				}
+				if !strings.Contains(response, "Allow: GET, HEAD, OPTIONS") {
+					t.Errorf("Route() Allow header missing or wrong in:\n%s", response)
+				}
+			}
 		})
 	}
 }
@@ -154,14 +167,14 @@ func TestServer_Route_PriorityOrder(t *testing.T) {
 
 	// Register handlers in specific order to test priority
 	handler1Called := false
-	handler1 := func(ctx context.Context, req *Request, w io.Writer) error {
+	handler1 := func(ctx context.Context, req *Request, w ResponseWriter) error {
 		handler1Called = true
 		w.Write([]byte("handler1"))
 		return nil
 	}
 
 	handler2Called := false
-	handler2 := func(ctx context.Context, req *Request, w io.Writer) error {
+	handler2 := func(ctx context.Context, req *Request, w ResponseWriter) error {
 		handler2Called = true
 		w.Write([]byte("handler2"))
 		return nil
@@ -176,7 +189,7 @@ func TestServer_Route_PriorityOrder(t *testing.T) {
 	ctx := context.Background()
 	req := createTestRequest("GET", "/api/specific/path", "HTTP/1.1", nil, nil)
 
-	err := server.Route(ctx, req, &buf)
+	err := routeRequest(ctx, server, req, &buf)
 	if err != nil {
 		t.Errorf("Route() error = %v", err)
 	}
@@ -260,12 +273,171 @@ func TestServer_Start_ShutdownSignal(t *testing.T) {
 	// Test passes if no deadlock occurs
 }
 
+func TestServer_HandleConn_LargeUpload(t *testing.T) {
+	server := createTestServer(t)
+	server.Register("POST", "/files/{name...}", server.filesPost)
+
+	client, conn := net.Pipe()
+	defer client.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- server.handleConn(conn)
+	}()
+
+	body := strings.Repeat("0123456789", 20000)
+	go func() {
+		// Write the request in several pieces so it spans multiple reads.
+		client.Write([]byte("POST /files/upload.txt HTTP/1.1\r\nHost: local"))
+		client.Write([]byte("host\r\nContent-Length: 200000\r\nConnection: close\r\n\r\n"))
+		for i := 0; i < len(body); i += 4096 {
+			client.Write([]byte(body[i:min(i+4096, len(body))]))
+		}
+	}()
+
+	response, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	if !strings.HasPrefix(string(response), "HTTP/1.1 201 Created") {
+		t.Errorf("handleConn() response = %q, want 201", response)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("handleConn() error = %v", err)
+	}
+
+	content, err := os.ReadFile(server.dir + "/upload.txt")
+	if err != nil {
+		t.Fatalf("Failed to read uploaded file: %v", err)
+	}
+	if len(content) != len(body) {
+		t.Errorf("uploaded file length = %d, want %d", len(content), len(body))
+	}
+}
+
+func TestServer_HandleConn_BodyTooLarge(t *testing.T) {
+	server := createTestServer(t)
+	server.maxBodyBytes = 16
+	server.Register("POST", "/files/{name...}", server.filesPost)
+
+	client, conn := net.Pipe()
+	defer client.Close()
+
+	go server.handleConn(conn)
+	go client.Write([]byte("POST /files/x HTTP/1.1\r\nContent-Length: 17\r\n\r\n"))
+
+	response, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	if !strings.HasPrefix(string(response), "HTTP/1.1 413 Request Entity Too Large") {
+		t.Errorf("handleConn() response = %q, want 413", response)
+	}
+	if !strings.Contains(string(response), "Connection: close") {
+		t.Errorf("handleConn() response = %q, want Connection: close", response)
+	}
+}
+
+// writeCountingConn counts the writes made to a connection.
+type writeCountingConn struct {
+	net.Conn
+	writes int
+}
+
+func (c *writeCountingConn) Write(b []byte) (int, error) {
+	c.writes++
+	return c.Conn.Write(b)
+}
+
+func TestServer_HandleConn_Pipelining(t *testing.T) {
+	tests := []struct {
+		name         string
+		maxPipelined int
+		wantWrites   int
+	}{
+		{name: "All responses in one batch", maxPipelined: 0, wantWrites: 1},
+		{name: "Batches capped", maxPipelined: 2, wantWrites: 2},
+		{name: "No batching", maxPipelined: 1, wantWrites: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := createTestServer(t)
+			server.maxPipelined = tt.maxPipelined
+			server.Register("GET", "/echo/{text...}", server.echoGet)
+			server.Register("POST", "/files/{name...}", server.filesPost)
+
+			client, conn := net.Pipe()
+			defer client.Close()
+			counting := &writeCountingConn{Conn: conn}
+
+			done := make(chan error, 1)
+			go func() {
+				done <- server.handleConn(counting)
+			}()
+
+			// All requests arrive in a single write, separated the way
+			// "echo -e" leaves them, and the last one closes the connection.
+			go client.Write([]byte("GET /echo/one HTTP/1.1\r\n\r\n\n" +
+				"POST /files/two.txt HTTP/1.1\r\nContent-Length: 3\r\n\r\ntwo" +
+				"GET /echo/three HTTP/1.1\r\n\r\n\n" +
+				"GET /echo/four HTTP/1.1\r\nConnection: close\r\n\r\n"))
+
+			br := bufio.NewReader(client)
+			var got []string
+			for range 4 {
+				resp, err := http.ReadResponse(br, nil)
+				if err != nil {
+					t.Fatalf("ReadResponse() error = %v", err)
+				}
+				body, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				got = append(got, fmt.Sprintf("%d %s", resp.StatusCode, body))
+			}
+			want := []string{"200 one", "201 ", "200 three", "200 four"}
+			if strings.Join(got, "|") != strings.Join(want, "|") {
+				t.Errorf("responses = %q, want %q", got, want)
+			}
+
+			if err := <-done; err != nil {
+				t.Errorf("handleConn() error = %v", err)
+			}
+			if counting.writes != tt.wantWrites {
+				t.Errorf("connection writes = %d, want %d", counting.writes, tt.wantWrites)
+			}
+		})
+	}
+}
+
+func TestServer_HandleConn_PartialPipelinedRequest(t *testing.T) {
+	server := createTestServer(t)
+	server.Register("GET", "/echo/{text...}", server.echoGet)
+
+	client, conn := net.Pipe()
+	defer client.Close()
+	go server.handleConn(conn)
+
+	// The second request's header is incomplete, so the first response
+	// must not wait for it.
+	go client.Write([]byte("GET /echo/one HTTP/1.1\r\n\r\nGET /echo/two HTTP/1.1\r\n"))
+
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+	if err != nil {
+		t.Fatalf("ReadResponse() error = %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "one" {
+		t.Errorf("first response body = %q, want one", body)
+	}
+}
+
 // Test route matching edge cases
 func TestServer_Route_EdgeCases(t *testing.T) {
 	server := createTestServer(t)
 
 	handlerCalled := false
-	testHandler := func(ctx context.Context, req *Request, w io.Writer) error {
+	testHandler := func(ctx context.Context, req *Request, w ResponseWriter) error {
 		handlerCalled = true
 		return nil
 	}
@@ -323,7 +495,7 @@ func TestServer_Route_EdgeCases(t *testing.T) {
 			var buf bytes.Buffer
 			ctx := context.Background()
 
-			err := server.Route(ctx, tt.request, &buf)
+			err := routeRequest(ctx, server, tt.request, &buf)
 			if err != nil {
 				t.Errorf("Route() error = %v", err)
 			}
@@ -340,12 +512,47 @@ func TestServer_Route_EdgeCases(t *testing.T) {
 	}
 }
 
+func TestServer_Route_DecodedPath(t *testing.T) {
+	server := createTestServer(t)
+	if err := os.WriteFile(filepath.Join(server.dir, "a.txt"), []byte("file a"), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	server.Register("GET", "/echo/{text...}", server.echoGet)
+	server.Register("GET", "/files/{name...}", server.filesGet)
+
+	tests := []struct {
+		name     string
+		target   string
+		wantBody string
+	}{
+		{name: "Echo decodes escapes", target: "/echo/hello%20world", wantBody: "hello world"},
+		{name: "Echo ignores the query", target: "/echo/abc?x=1", wantBody: "abc"},
+		{name: "File with query", target: "/files/a.txt?download=1", wantBody: "file a"},
+		{name: "Encoded file name", target: "/files/%61.txt", wantBody: "file a"},
+		{name: "Encoded route segment", target: "/%65cho/x", wantBody: "x"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			req := createTestRequest("GET", tt.target, "HTTP/1.1", nil, nil)
+			if err := routeRequest(context.Background(), server, req, &buf); err != nil {
+				t.Fatalf("Route() error = %v", err)
+			}
+			statusCode, _, body := parseHTTPResponse(buf.String())
+			if statusCode != 200 || body != tt.wantBody {
+				t.Errorf("Route(%s) = %d %q, want 200 %q", tt.target, statusCode, body, tt.wantBody)
+			}
+		})
+	}
+}
+
 // Benchmark tests for server operations
 func BenchmarkServer_Route(b *testing.B) {
 	server := createTestServer(&testing.T{})
 
-	handler := func(ctx context.Context, req *Request, w io.Writer) error {
-		return httpResponse(w, 200, nil, "OK")
+	handler := func(ctx context.Context, req *Request, w ResponseWriter) error {
+		return httpResponse(w, 200, "OK")
 	}
 
 	// Register multiple routes
@@ -361,14 +568,14 @@ func BenchmarkServer_Route(b *testing.B) {
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		var buf bytes.Buffer
-		server.Route(ctx, request, &buf)
+		routeRequest(ctx, server, request, &buf)
 	}
 }
 
 func BenchmarkServer_Register(b *testing.B) {
 	server := createTestServer(&testing.T{})
 
-	handler := func(ctx context.Context, req *Request, w io.Writer) error {
+	handler := func(ctx context.Context, req *Request, w ResponseWriter) error {
 		return nil
 	}
 
@@ -377,3 +584,47 @@ func BenchmarkServer_Register(b *testing.B) {
 		server.Register("GET", "/test", handler)
 	}
 }
+
+func TestServer_Start_StopsPromptly(t *testing.T) {
+	listener := createMockTCPListener(t)
+	defer listener.Close()
+
+	server := NewServer("/tmp", listener, make(chan os.Signal, 1))
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.Start(ctx)
+	}()
+
+	// Let Start block in Accept before cancelling.
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-errCh:
+		if err != context.Canceled {
+			t.Errorf("Start() error = %v, want context.Canceled", err)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Error("Start() still accepting 100ms after cancellation")
+	}
+
+	if _, err := net.Dial("tcp", listener.Addr().String()); err == nil {
+		t.Error("listener still open after shutdown")
+	}
+}
+
+func TestNextAcceptBackoff(t *testing.T) {
+	want := []time.Duration{
+		5 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond,
+		40 * time.Millisecond, 80 * time.Millisecond, 160 * time.Millisecond,
+		320 * time.Millisecond, 640 * time.Millisecond, time.Second, time.Second,
+	}
+	var d time.Duration
+	for i, w := range want {
+		d = nextAcceptBackoff(d)
+		if d != w {
+			t.Errorf("backoff #%d = %v, want %v", i+1, d, w)
+		}
+	}
+}
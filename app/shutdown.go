@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// defaultShutdownTimeout is how long Start lets in-flight requests finish
+// after a shutdown signal before closing their connections.
+const defaultShutdownTimeout = 30 * time.Second
+
+// errServerClosed is returned when reading the next request of a connection
+// that is being closed because the server is shutting down.
+var errServerClosed = errors.New("server is shutting down")
+
+// connState tells Shutdown whether a connection can be closed right away.
+type connState int
+
+const (
+	// stateActive connections are reading a request or running its handler.
+	stateActive connState = iota
+	// stateIdle connections are waiting for the next request.
+	stateIdle
+)
+
+// trackConn registers conn with the server. It reports false if the server
+// is shutting down, in which case conn must not be served.
+func (s *server) trackConn(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.inShutdown {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]connState)
+	}
+	s.conns[conn] = stateActive
+	s.connWG.Add(1)
+	return true
+}
+
+func (s *server) untrackConn(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	s.connWG.Done()
+}
+
+// setConnState records the state of conn. It reports false when conn goes
+// idle during shutdown and should be closed instead of waiting.
+func (s *server) setConnState(conn net.Conn, state connState) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.conns[conn]; ok {
+		s.conns[conn] = state
+	}
+	return !(s.inShutdown && state == stateIdle)
+}
+
+func (s *server) shuttingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inShutdown
+}
+
+// closing returns a channel that is closed when Shutdown is called.
+func (s *server) closing() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closingLocked()
+}
+
+func (s *server) closingLocked() chan struct{} {
+	if s.closingCh == nil {
+		s.closingCh = make(chan struct{})
+	}
+	return s.closingCh
+}
+
+// Shutdown closes the listener so that Start stops accepting, closes idle
+// keep-alive connections and waits for in-flight requests to finish. Their
+// responses are sent with Connection: close. If ctx ends first, the
+// remaining connections are closed and Shutdown returns how many were
+// aborted along with the context's error.
+func (s *server) Shutdown(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	if !s.inShutdown {
+		s.inShutdown = true
+		close(s.closingLocked())
+		if s.listener != nil {
+			s.listener.Close()
+		}
+	}
+	for conn, state := range s.conns {
+		if state == stateIdle {
+			conn.Close()
+		}
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.connWG.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return 0, nil
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	return len(s.conns), ctx.Err()
+}
+
+// shutdownContext returns the context bounding a graceful shutdown started
+// by Start. Zero means defaultShutdownTimeout and a negative duration waits
+// for in-flight requests indefinitely.
+func (s *server) shutdownContext() (context.Context, context.CancelFunc) {
+	switch {
+	case s.shutdownTimeout < 0:
+		return context.WithCancel(context.Background())
+	case s.shutdownTimeout == 0:
+		return context.WithTimeout(context.Background(), defaultShutdownTimeout)
+	}
+	return context.WithTimeout(context.Background(), s.shutdownTimeout)
+}
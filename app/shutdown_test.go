@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+// waitShuttingDown waits until Shutdown has been called on s.
+func waitShuttingDown(t *testing.T, s *server) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !s.shuttingDown(); {
+		if time.Now().After(deadline) {
+			t.Fatal("server did not start shutting down")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestShutdown_ClosesIdleConnections(t *testing.T) {
+	server := createTestServer(t)
+	server.Register("GET", "/{$}", server.rootGet)
+
+	client, done := serveConn(t, server)
+	client.SetDeadline(time.Now().Add(2 * time.Second))
+	go client.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	br := bufio.NewReader(client)
+	if _, err := http.ReadResponse(br, nil); err != nil {
+		t.Fatalf("ReadResponse() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	aborted, err := server.Shutdown(ctx)
+	if err != nil || aborted != 0 {
+		t.Errorf("Shutdown() = %d, %v, want 0, nil", aborted, err)
+	}
+	waitClosed(t, done, time.Second)
+	if _, err := br.ReadByte(); err == nil {
+		t.Error("idle connection still open after Shutdown")
+	}
+}
+
+func TestShutdown_DrainsInFlightRequests(t *testing.T) {
+	server := createTestServer(t)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server.Register("GET", "/slow", func(ctx context.Context, req *Request, w ResponseWriter) error {
+		close(started)
+		<-release
+		return httpResponse(w, http.StatusOK, "done")
+	})
+
+	client, done := serveConn(t, server)
+	client.SetDeadline(time.Now().Add(2 * time.Second))
+	go client.Write([]byte("GET /slow HTTP/1.1\r\n\r\n"))
+	<-started
+
+	type result struct {
+		aborted int
+		err     error
+	}
+	shutdownDone := make(chan result, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		aborted, err := server.Shutdown(ctx)
+		shutdownDone <- result{aborted, err}
+	}()
+	waitShuttingDown(t, server)
+
+	select {
+	case <-shutdownDone:
+		t.Fatal("Shutdown() returned while a request was in flight")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+
+	resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+	if err != nil {
+		t.Fatalf("ReadResponse() error = %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "done" {
+		t.Errorf("response body = %q, want done", body)
+	}
+	if !resp.Close {
+		t.Error("response during shutdown should carry Connection: close")
+	}
+
+	if r := <-shutdownDone; r.err != nil || r.aborted != 0 {
+		t.Errorf("Shutdown() = %d, %v, want 0, nil", r.aborted, r.err)
+	}
+	waitClosed(t, done, time.Second)
+}
+
+func TestShutdown_DeadlineAbortsConnections(t *testing.T) {
+	server := createTestServer(t)
+	started := make(chan struct{})
+	handlerErr := make(chan error, 1)
+	server.Register("GET", "/stuck", func(ctx context.Context, req *Request, w ResponseWriter) error {
+		close(started)
+		<-ctx.Done()
+		handlerErr <- ctx.Err()
+		return nil
+	})
+
+	client, _ := serveConn(t, server)
+	go client.Write([]byte("GET /stuck HTTP/1.1\r\n\r\n"))
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	aborted, err := server.Shutdown(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) || aborted != 1 {
+		t.Errorf("Shutdown() = %d, %v, want 1, context.DeadlineExceeded", aborted, err)
+	}
+
+	select {
+	case err := <-handlerErr:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("handler context error = %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("handler context not cancelled after the connection was aborted")
+	}
+}
+
+func TestShutdown_RefusesNewConnections(t *testing.T) {
+	server := createTestServer(t)
+	if _, err := server.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+
+	client, done := serveConn(t, server)
+	if err := waitClosed(t, done, time.Second); err != nil {
+		t.Errorf("handleConn() error = %v", err)
+	}
+	if _, err := client.Write([]byte("GET / HTTP/1.1\r\n\r\n")); err == nil {
+		t.Error("connection accepted after Shutdown")
+	}
+}
+
+func TestServer_Start_DrainsOnCancel(t *testing.T) {
+	listener := createMockTCPListener(t)
+	defer listener.Close()
+
+	server := NewServer(t.TempDir(), listener, make(chan os.Signal, 1))
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server.Register("GET", "/slow", func(ctx context.Context, req *Request, w ResponseWriter) error {
+		close(started)
+		<-release
+		return httpResponse(w, http.StatusOK, "done")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.Start(ctx)
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	if _, err := conn.Write([]byte("GET /slow HTTP/1.1\r\n\r\n")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	<-started
+
+	cancel()
+	waitShuttingDown(t, server)
+	select {
+	case err := <-errCh:
+		t.Fatalf("Start() returned %v while a request was in flight", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("ReadResponse() error = %v", err)
+	}
+	if !resp.Close {
+		t.Error("response during shutdown should carry Connection: close")
+	}
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Start() error = %v, want context.Canceled", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Start() did not return after draining")
+	}
+}
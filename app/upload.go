@@ -0,0 +1,302 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash/maphash"
+	"io"
+	"io/fs"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// uploadLocks serialize the final check-and-rename of uploads to the same
+// name, so that conditional uploads cannot both succeed. Names are hashed
+// onto a fixed set of mutexes.
+var (
+	uploadLocks [64]sync.Mutex
+	uploadSeed  = maphash.MakeSeed()
+)
+
+func uploadLock(name string) *sync.Mutex {
+	return &uploadLocks[maphash.String(uploadSeed, name)%uint64(len(uploadLocks))]
+}
+
+// stat returns the info of name, or nil if it does not exist, and the
+// entity tag of a file. Directories have no entity tag.
+func (s *server) stat(name string) (etag string, info os.FileInfo, err error) {
+	f, err := s.files().open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+
+	info, err = f.Stat()
+	if err != nil || info.IsDir() {
+		return "", info, err
+	}
+	etag, err = s.fileETag(f, info)
+	return etag, info, err
+}
+
+// checkWritePreconditions evaluates the conditional headers of an upload
+// to name, returning a status error if it may not proceed and otherwise
+// the info of name, or nil if it does not exist. Directories cannot be
+// written to.
+func (s *server) checkWritePreconditions(req *Request, name string) (os.FileInfo, error) {
+	etag, info, err := s.stat(name)
+	if err != nil {
+		return nil, err
+	}
+	var modTime time.Time
+	if info != nil {
+		if info.IsDir() {
+			return nil, newStatusError(http.StatusConflict, "%s is a directory", name)
+		}
+		modTime = info.ModTime()
+	}
+	if code := checkPreconditions(req, etag, modTime, info != nil); code != 0 {
+		return nil, newStatusError(code, "precondition failed for %s", name)
+	}
+	return info, nil
+}
+
+// storeFile replaces name with the body of req and returns the new entity
+// tag and whether the file was created. The body is written to a temporary
+// file next to name that is renamed over it once complete, so readers never
+// see a partial upload and concurrent uploads do not interleave. The
+// request's If-Match, If-None-Match and date preconditions are checked
+// before the body is read and again just before the rename.
+func (s *server) storeFile(req *Request, name string) (etag string, created bool, err error) {
+	fr := s.files()
+	clean, err := fr.cleanFileName(name)
+	if err != nil {
+		return "", false, err
+	}
+	if _, err := s.checkWritePreconditions(req, name); err != nil {
+		return "", false, err
+	}
+
+	// The temporary file is created through the root, which checks that
+	// its directory, also the target's, lies inside the root. The os.Root
+	// of Go 1.24, which the module targets, has no Rename, so the rename
+	// below and the cleanup here go through the directory's path instead.
+	// Nothing served by the API can swap that directory for a symlink in
+	// between: requests only ever create regular files, and directories
+	// cannot be written to or removed. Only someone with local access to
+	// the root could, and they can already write anywhere it leads.
+	tmp, tmpName, err := fr.createTemp(clean)
+	if err != nil {
+		return "", false, err
+	}
+	renamed := false
+	defer func() {
+		if !renamed {
+			os.Remove(filepath.Join(fr.dir, tmpName))
+		}
+	}()
+
+	_, err = io.Copy(tmp, req.BodyReader())
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	mu := uploadLock(clean)
+	mu.Lock()
+	defer mu.Unlock()
+
+	info, err := s.checkWritePreconditions(req, name)
+	if err != nil {
+		return "", false, err
+	}
+	if err := os.Rename(filepath.Join(fr.dir, tmpName), filepath.Join(fr.dir, clean)); err != nil {
+		return "", false, fmt.Errorf("failed to store file: %w", err)
+	}
+	renamed = true
+
+	etag, _, err = s.stat(name)
+	return etag, info == nil, err
+}
+
+// createTemp creates a temporary file next to the file clean, hidden from
+// directory listings by a leading dot, and returns it with its name.
+func (fr fileRoot) createTemp(clean string) (*os.File, string, error) {
+	tmpName := filepath.Join(filepath.Dir(clean), "."+filepath.Base(clean)+".tmp-"+strconv.FormatUint(rand.Uint64(), 36))
+	f, err := fr.openFile(filepath.ToSlash(tmpName), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644)
+	return f, tmpName, err
+}
+
+// fileLocation returns the escaped URL path of the file name, for the
+// Location header.
+func fileLocation(name string) string {
+	return (&url.URL{Path: "/files/" + name}).EscapedPath()
+}
+
+// appendFile appends the body of req to name, creating it if it does not
+// exist, and returns the new entity tag. A Content-Range header of the form
+// "bytes start-end/*" must start at the current end of the file, so that a
+// retried append is not applied twice, and cover exactly the body; a failed
+// append is rolled back. The body is read into a temporary file first, and
+// only copied onto name under its upload lock, so that a slow client does
+// not hold up writes to other names sharing the lock.
+func (s *server) appendFile(req *Request, name string) (etag string, err error) {
+	fr := s.files()
+	clean, err := fr.cleanFileName(name)
+	if err != nil {
+		return "", err
+	}
+	info, err := s.checkWritePreconditions(req, name)
+	if err != nil {
+		return "", err
+	}
+
+	// A stale offset is rejected before the body is requested, so that a
+	// client retrying an append with Expect: 100-continue need not send it
+	// again. The offset is checked once more under the lock.
+	r := byteRange{start: -1, length: -1}
+	if v, ok := req.Headers.Get(HeaderContentRange); ok {
+		if r, err = parseAppendRange(v); err != nil {
+			return "", err
+		}
+		if err := checkAppendOffset(r, info, name); err != nil {
+			return "", err
+		}
+	}
+	body := req.BodyReader()
+	if r.length >= 0 {
+		body = io.LimitReader(body, r.length+1)
+	}
+
+	tmp, tmpName, err := fr.createTemp(clean)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		tmp.Close()
+		fr.remove(filepath.ToSlash(tmpName))
+	}()
+	n, err := io.Copy(tmp, body)
+	if err != nil {
+		return "", err
+	}
+	if r.length >= 0 && n != r.length {
+		return "", newStatusError(http.StatusBadRequest, "body has %d bytes, Content-Range %d", n, r.length)
+	}
+
+	mu := uploadLock(clean)
+	mu.Lock()
+	defer mu.Unlock()
+
+	existing, err := s.checkWritePreconditions(req, name)
+	if err != nil {
+		return "", err
+	}
+	f, err := fr.openAppend(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err = f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if err := checkAppendOffset(r, info, name); err != nil {
+		return "", err
+	}
+
+	_, err = io.Copy(f, io.NewSectionReader(tmp, 0, n))
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		if existing != nil {
+			f.Truncate(info.Size())
+		} else {
+			fr.remove(name)
+		}
+		return "", err
+	}
+
+	etag, _, err = s.stat(name)
+	return etag, err
+}
+
+// checkAppendOffset returns a conflict if the append range r does not start
+// at the end of name, which has the given info or does not exist if it is
+// nil. A range with a negative start, standing for none, always fits.
+func checkAppendOffset(r byteRange, info os.FileInfo, name string) error {
+	var size int64
+	if info != nil {
+		size = info.Size()
+	}
+	if r.start >= 0 && r.start != size {
+		return newStatusError(http.StatusConflict, "append starts at %d but %s has %d bytes", r.start, name, size)
+	}
+	return nil
+}
+
+// parseAppendRange parses the Content-Range of an append, "bytes
+// start-end/total" where total may be "*".
+func parseAppendRange(v string) (byteRange, error) {
+	invalid := newStatusError(http.StatusBadRequest, "invalid Content-Range %q", v)
+	unit, spec, ok := strings.Cut(v, " ")
+	if !ok || unit != RangeUnitBytes {
+		return byteRange{}, invalid
+	}
+	spec, _, ok = strings.Cut(spec, "/")
+	if !ok {
+		return byteRange{}, invalid
+	}
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok {
+		return byteRange{}, invalid
+	}
+	start, err := parseRangeInt(first)
+	if err != nil {
+		return byteRange{}, invalid
+	}
+	end, err := parseRangeInt(last)
+	if err != nil || end < start {
+		return byteRange{}, invalid
+	}
+	return byteRange{start: start, length: end - start + 1}, nil
+}
+
+// deleteFile removes the file name, honoring the request's preconditions.
+// Directories are not removed.
+func (s *server) deleteFile(req *Request, name string) error {
+	fr := s.files()
+	clean, err := fr.cleanFileName(name)
+	if err != nil {
+		return err
+	}
+
+	mu := uploadLock(clean)
+	mu.Lock()
+	defer mu.Unlock()
+
+	info, err := s.checkWritePreconditions(req, name)
+	if err != nil {
+		return err
+	}
+	if info == nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	return fr.remove(name)
+}
@@ -0,0 +1,516 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// postFile runs filesPost for name with the given headers and body and
+// parses the response.
+func postFile(t *testing.T, server *server, name string, headers map[string]string, body string) *http.Response {
+	t.Helper()
+	return sendFile(t, server, "POST", name, headers, body)
+}
+
+// sendFile runs the files handler for method on name with the given headers
+// and body and parses the response.
+func sendFile(t *testing.T, server *server, method, name string, headers map[string]string, body string) *http.Response {
+	t.Helper()
+	handlers := map[string]handleFunc{
+		"POST":   server.filesPost,
+		"PUT":    server.filesPut,
+		"PATCH":  server.filesPatch,
+		"DELETE": server.filesDelete,
+	}
+	req := createTestRequest(method, "/files/"+name, "HTTP/1.1", headers, []byte(body))
+	buf := captureResponse(t, handlers[method], withPathValue(req, "name", "/files/"))
+	resp, err := http.ReadResponse(bufio.NewReader(buf), nil)
+	if err != nil {
+		t.Fatalf("ReadResponse() error = %v", err)
+	}
+	return resp
+}
+
+// dirNames lists the names in dir.
+func dirNames(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestFilesPost_ETagAndLocation(t *testing.T) {
+	server := createTestServer(t)
+
+	resp := postFile(t, server, "a b.txt", nil, "hello")
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("status = %d, want 201", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Location"); got != "/files/a%20b.txt" {
+		t.Errorf("Location = %q, want /files/a%%20b.txt", got)
+	}
+
+	get, _ := getFile(t, server, "GET", "a b.txt", nil)
+	if etag := resp.Header.Get("ETag"); etag == "" || etag != get.Header.Get("ETag") {
+		t.Errorf("upload ETag = %q, want the file's ETag %q", etag, get.Header.Get("ETag"))
+	}
+	if names := dirNames(t, server.dir); len(names) != 1 {
+		t.Errorf("directory holds %v, want only the uploaded file", names)
+	}
+}
+
+func TestFilesPost_Conditional(t *testing.T) {
+	tests := []struct {
+		name        string
+		existing    bool
+		headers     func(etag string) map[string]string
+		wantCode    int
+		wantContent string
+	}{
+		{
+			name:        "Create-only on new file",
+			headers:     func(string) map[string]string { return map[string]string{"If-None-Match": "*"} },
+			wantCode:    201,
+			wantContent: "new",
+		},
+		{
+			name:        "Create-only on existing file",
+			existing:    true,
+			headers:     func(string) map[string]string { return map[string]string{"If-None-Match": "*"} },
+			wantCode:    412,
+			wantContent: "old",
+		},
+		{
+			name:        "Update with current ETag",
+			existing:    true,
+			headers:     func(etag string) map[string]string { return map[string]string{"If-Match": etag} },
+			wantCode:    201,
+			wantContent: "new",
+		},
+		{
+			name:        "Update with stale ETag",
+			existing:    true,
+			headers:     func(string) map[string]string { return map[string]string{"If-Match": `"stale"`} },
+			wantCode:    412,
+			wantContent: "old",
+		},
+		{
+			name:     "Update of missing file",
+			headers:  func(string) map[string]string { return map[string]string{"If-Match": "*"} },
+			wantCode: 412,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := createTestServer(t)
+			var etag string
+			if tt.existing {
+				etag = postFile(t, server, "f.txt", nil, "old").Header.Get("ETag")
+			}
+
+			resp := postFile(t, server, "f.txt", tt.headers(etag), "new")
+			if resp.StatusCode != tt.wantCode {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantCode)
+			}
+
+			content, err := os.ReadFile(filepath.Join(server.dir, "f.txt"))
+			if tt.wantContent == "" {
+				if err == nil {
+					t.Errorf("file created with %q, want none", content)
+				}
+			} else if string(content) != tt.wantContent {
+				t.Errorf("file content = %q, want %q", content, tt.wantContent)
+			}
+			for _, name := range dirNames(t, server.dir) {
+				if strings.Contains(name, ".tmp-") {
+					t.Errorf("temporary file %s left behind", name)
+				}
+			}
+		})
+	}
+}
+
+func TestFilesPost_FailedUploadKeepsOriginal(t *testing.T) {
+	server := createTestServer(t)
+	postFile(t, server, "f.txt", nil, "original")
+
+	// The body ends before its declared length.
+	req := createTestRequest("POST", "/files/f.txt", "HTTP/1.1", nil, nil)
+	req.body = &bodyReader{r: strings.NewReader("trunc"), req: req, length: 100, limit: 1000}
+	buf := captureResponse(t, server.filesPost, withPathValue(req, "name", "/files/"))
+	resp, err := http.ReadResponse(bufio.NewReader(buf), nil)
+	if err != nil {
+		t.Fatalf("ReadResponse() error = %v", err)
+	}
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", resp.StatusCode)
+	}
+
+	content, _ := os.ReadFile(filepath.Join(server.dir, "f.txt"))
+	if string(content) != "original" {
+		t.Errorf("file content = %q, want original", content)
+	}
+	if names := dirNames(t, server.dir); len(names) != 1 {
+		t.Errorf("directory holds %v, want only the original file", names)
+	}
+}
+
+func TestFilesPost_ConcurrentUploads(t *testing.T) {
+	server := createTestServer(t)
+
+	bodies := make(map[string]bool)
+	var wg sync.WaitGroup
+	for i := range 8 {
+		body := strings.Repeat(fmt.Sprint(i), 256*1024)
+		bodies[body] = true
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := createTestRequest("POST", "/files/shared.bin", "HTTP/1.1", nil, []byte(body))
+			captureResponse(t, server.filesPost, withPathValue(req, "name", "/files/"))
+		}()
+	}
+	wg.Wait()
+
+	content, err := os.ReadFile(filepath.Join(server.dir, "shared.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bodies[string(content)] {
+		t.Errorf("file content is a mix of uploads (%d bytes)", len(content))
+	}
+}
+
+func TestFilesPost_ConcurrentCreateOnly(t *testing.T) {
+	server := createTestServer(t)
+
+	var mu sync.Mutex
+	created := 0
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := createTestRequest("POST", "/files/once.txt", "HTTP/1.1", map[string]string{"If-None-Match": "*"}, []byte("x"))
+			buf := captureResponse(t, server.filesPost, withPathValue(req, "name", "/files/"))
+			if strings.HasPrefix(buf.String(), "HTTP/1.1 201") {
+				mu.Lock()
+				created++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if created != 1 {
+		t.Errorf("%d create-only uploads succeeded, want 1", created)
+	}
+}
+
+func TestFilesPut(t *testing.T) {
+	server := createTestServer(t)
+
+	resp := sendFile(t, server, "PUT", "f.txt", nil, "first")
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("create status = %d, want 201", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Location"); got != "/files/f.txt" {
+		t.Errorf("Location = %q, want /files/f.txt", got)
+	}
+	etag := resp.Header.Get("ETag")
+
+	resp = sendFile(t, server, "PUT", "f.txt", map[string]string{"If-Match": etag}, "second")
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("replace status = %d, want 204", resp.StatusCode)
+	}
+	if resp.Header.Get("ETag") == "" {
+		t.Error("replace response has no ETag")
+	}
+
+	resp = sendFile(t, server, "PUT", "f.txt", map[string]string{"If-Match": etag}, "third")
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("stale replace status = %d, want 412", resp.StatusCode)
+	}
+
+	content, _ := os.ReadFile(filepath.Join(server.dir, "f.txt"))
+	if string(content) != "second" {
+		t.Errorf("file content = %q, want second", content)
+	}
+}
+
+func TestFileError_StatusReason(t *testing.T) {
+	server := createTestServer(t)
+	postFile(t, server, "f.txt", nil, "content")
+
+	resp := sendFile(t, server, "PUT", "f.txt", map[string]string{"If-Match": `"stale"`}, "new")
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("status = %d, want 412", resp.StatusCode)
+	}
+	if want := "precondition failed for f.txt"; string(body) != want {
+		t.Errorf("body = %q, want %q", body, want)
+	}
+}
+
+func TestFilesWrite_InvalidTargets(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		wantCode int
+	}{
+		{name: "Directory", method: "PUT", target: "sub", wantCode: 409},
+		{name: "Directory by POST", method: "POST", target: "sub", wantCode: 409},
+		{name: "Empty name", method: "POST", target: "", wantCode: 400},
+		{name: "Trailing slash", method: "PUT", target: "sub/", wantCode: 400},
+		{name: "Dot", method: "PUT", target: ".", wantCode: 400},
+		{name: "Append to directory", method: "PATCH", target: "sub", wantCode: 409},
+		{name: "Append to empty name", method: "PATCH", target: "", wantCode: 400},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := createTestServer(t)
+			if err := os.Mkdir(filepath.Join(server.dir, "sub"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+
+			resp := sendFile(t, server, tt.method, tt.target, nil, "content")
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tt.wantCode {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantCode)
+			}
+			if strings.Contains(string(body), server.dir) {
+				t.Errorf("body %q reveals the server's directory", body)
+			}
+			if names := dirNames(t, server.dir); len(names) != 1 {
+				t.Errorf("directory holds %v, want only sub", names)
+			}
+		})
+	}
+}
+
+func TestFileError_InternalErrorHidden(t *testing.T) {
+	server := createTestServer(t)
+	server.etagMode = "unknown"
+	if err := os.WriteFile(filepath.Join(server.dir, "f.txt"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, body := getFile(t, server, "GET", "f.txt", nil)
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", resp.StatusCode)
+	}
+	if body != "Internal Server Error" {
+		t.Errorf("body = %q, want a generic reason", body)
+	}
+}
+
+func TestFilesDelete(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		headers  map[string]string
+		wantCode int
+		wantGone bool
+	}{
+		{name: "Existing file", target: "f.txt", wantCode: 204, wantGone: true},
+		{name: "Missing file", target: "missing.txt", wantCode: 404},
+		{name: "Directory", target: "dir", wantCode: 409},
+		{name: "Stale ETag", target: "f.txt", headers: map[string]string{"If-Match": `"stale"`}, wantCode: 412},
+		{name: "Outside root", target: "../f.txt", wantCode: 400},
+		{name: "Empty name", target: "", wantCode: 400},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := createTestServer(t)
+			postFile(t, server, "f.txt", nil, "content")
+			if err := os.Mkdir(filepath.Join(server.dir, "dir"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+
+			resp := sendFile(t, server, "DELETE", tt.target, tt.headers, "")
+			if resp.StatusCode != tt.wantCode {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantCode)
+			}
+			_, err := os.Stat(filepath.Join(server.dir, "f.txt"))
+			if gone := os.IsNotExist(err); gone != tt.wantGone {
+				t.Errorf("f.txt removed = %v, want %v", gone, tt.wantGone)
+			}
+		})
+	}
+}
+
+func TestFilesPatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		existing    string
+		headers     map[string]string
+		body        string
+		wantCode    int
+		wantContent string
+	}{
+		{name: "Append", existing: "abc", body: "def", wantCode: 204, wantContent: "abcdef"},
+		{name: "Create", body: "abc", wantCode: 204, wantContent: "abc"},
+		{
+			name:        "Append at end",
+			existing:    "abc",
+			headers:     map[string]string{"Content-Range": "bytes 3-5/*"},
+			body:        "def",
+			wantCode:    204,
+			wantContent: "abcdef",
+		},
+		{
+			name:        "Retried append",
+			existing:    "abcdef",
+			headers:     map[string]string{"Content-Range": "bytes 3-5/*"},
+			body:        "def",
+			wantCode:    409,
+			wantContent: "abcdef",
+		},
+		{
+			name:        "Range longer than body",
+			existing:    "abc",
+			headers:     map[string]string{"Content-Range": "bytes 3-9/*"},
+			body:        "def",
+			wantCode:    400,
+			wantContent: "abc",
+		},
+		{
+			name:        "Malformed range",
+			existing:    "abc",
+			headers:     map[string]string{"Content-Range": "bytes=3-5"},
+			body:        "def",
+			wantCode:    400,
+			wantContent: "abc",
+		},
+		{
+			name:        "Stale ETag",
+			existing:    "abc",
+			headers:     map[string]string{"If-Match": `"stale"`},
+			body:        "def",
+			wantCode:    412,
+			wantContent: "abc",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := createTestServer(t)
+			if tt.existing != "" {
+				postFile(t, server, "log.txt", nil, tt.existing)
+			}
+
+			resp := sendFile(t, server, "PATCH", "log.txt", tt.headers, tt.body)
+			if resp.StatusCode != tt.wantCode {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantCode)
+			}
+			if resp.StatusCode == http.StatusNoContent && resp.Header.Get("ETag") == "" {
+				t.Error("append response has no ETag")
+			}
+			content, _ := os.ReadFile(filepath.Join(server.dir, "log.txt"))
+			if string(content) != tt.wantContent {
+				t.Errorf("file content = %q, want %q", content, tt.wantContent)
+			}
+			for _, name := range dirNames(t, server.dir) {
+				if strings.Contains(name, ".tmp-") {
+					t.Errorf("temporary file %s left behind", name)
+				}
+			}
+		})
+	}
+}
+
+func TestFilesPatch_FailedAppendRollsBack(t *testing.T) {
+	server := createTestServer(t)
+	postFile(t, server, "log.txt", nil, "abc")
+
+	req := createTestRequest("PATCH", "/files/log.txt", "HTTP/1.1", nil, nil)
+	req.body = &bodyReader{r: strings.NewReader("trunc"), req: req, length: 100, limit: 1000}
+	captureResponse(t, server.filesPatch, withPathValue(req, "name", "/files/"))
+
+	content, _ := os.ReadFile(filepath.Join(server.dir, "log.txt"))
+	if string(content) != "abc" {
+		t.Errorf("file content = %q, want abc", content)
+	}
+}
+
+func TestFilesPatch_SlowBodyDoesNotHoldLock(t *testing.T) {
+	server := createTestServer(t)
+
+	// Find another name sharing the slow append's upload lock.
+	other := ""
+	for i := 0; other == ""; i++ {
+		if name := fmt.Sprintf("other-%d.txt", i); uploadLock(name) == uploadLock("slow.log") {
+			other = name
+		}
+	}
+
+	pr, pw := io.Pipe()
+	req := createTestRequest("PATCH", "/files/slow.log", "HTTP/1.1", nil, nil)
+	req.body = &bodyReader{r: pr, req: req, length: 6, limit: 1000}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		captureResponse(t, server.filesPatch, withPathValue(req, "name", "/files/"))
+	}()
+	// The write returns once the append is reading the body.
+	pw.Write([]byte("abc"))
+
+	stored := make(chan struct{})
+	go func() {
+		defer close(stored)
+		sendFile(t, server, "PUT", other, nil, "content")
+	}()
+	select {
+	case <-stored:
+	case <-time.After(2 * time.Second):
+		t.Error("PUT waited for a slow append to another name")
+	}
+
+	pw.Write([]byte("def"))
+	pw.Close()
+	<-done
+	<-stored
+	content, _ := os.ReadFile(filepath.Join(server.dir, "slow.log"))
+	if string(content) != "abcdef" {
+		t.Errorf("file content = %q, want abcdef", content)
+	}
+}
+
+func TestFilesPatch_StaleOffsetBeforeContinue(t *testing.T) {
+	server := createTestServer(t)
+	server.Register("PATCH", "/files/{name...}", server.filesPatch)
+	postFile(t, server, "log.txt", nil, "abc")
+	client, br := startConn(t, server)
+
+	go client.Write([]byte("PATCH /files/log.txt HTTP/1.1\r\nContent-Length: 3\r\nContent-Range: bytes 0-2/*\r\nExpect: 100-continue\r\n\r\n"))
+
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("ReadResponse() error = %v", err)
+	}
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("status = %d, want 409 before the body is requested", resp.StatusCode)
+	}
+	content, _ := os.ReadFile(filepath.Join(server.dir, "log.txt"))
+	if string(content) != "abc" {
+		t.Errorf("file content = %q, want abc", content)
+	}
+}
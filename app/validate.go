@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// parseRequestLine parses "method SP request-target SP HTTP-version"
+// (RFC 9112 section 3). In strict mode the line must have exactly three
+// parts separated by single spaces, the method must be a token, the target
+// must be one of the forms the server accepts and the version must be
+// HTTP/1.0 or HTTP/1.1; other well-formed versions get 505. Lenient mode
+// splits on any run of whitespace and accepts extra parts and any version.
+func parseRequestLine(line string, lenient bool) (*Request, error) {
+	if lenient {
+		parts := strings.Fields(line)
+		if len(parts) < 3 {
+			return nil, newStatusError(http.StatusBadRequest, "invalid request line: expected 3 parts, got %d", len(parts))
+		}
+		r := &Request{Method: parts[0], Target: parts[1], Version: parts[2]}
+		if err := r.parseTarget(); err != nil {
+			return nil, newStatusError(http.StatusBadRequest, "%v", err)
+		}
+		return r, nil
+	}
+
+	parts := strings.Split(line, " ")
+	if len(parts) != 3 {
+		return nil, newStatusError(http.StatusBadRequest, "invalid request line %q: expected 3 parts separated by single spaces", line)
+	}
+	r := &Request{Method: parts[0], Target: parts[1], Version: parts[2]}
+
+	if !isToken(r.Method) {
+		return nil, newStatusError(http.StatusBadRequest, "invalid method %q", r.Method)
+	}
+	if err := checkVersion(r.Version); err != nil {
+		return nil, err
+	}
+	if r.Target == "" || strings.IndexFunc(r.Target, func(c rune) bool { return c <= ' ' || c >= 0x7f }) >= 0 {
+		return nil, newStatusError(http.StatusBadRequest, "invalid request target %q", r.Target)
+	}
+	if r.Target == "*" && r.Method != http.MethodOptions {
+		return nil, newStatusError(http.StatusBadRequest, "asterisk-form target is only allowed for OPTIONS")
+	}
+	if err := r.parseTarget(); err != nil {
+		return nil, newStatusError(http.StatusBadRequest, "%v", err)
+	}
+	return r, nil
+}
+
+// checkVersion accepts the HTTP versions the server speaks. A syntactically
+// valid HTTP-version of any other value is answered with 505.
+func checkVersion(v string) error {
+	switch v {
+	case "HTTP/1.0", "HTTP/1.1":
+		return nil
+	}
+	if len(v) == len("HTTP/x.y") && strings.HasPrefix(v, "HTTP/") &&
+		isDigit(v[5]) && v[6] == '.' && isDigit(v[7]) {
+		return newStatusError(http.StatusHTTPVersionNotSupported, "unsupported version %q", v)
+	}
+	return newStatusError(http.StatusBadRequest, "invalid version %q", v)
+}
+
+// parseFieldLine splits a header field line into its name and value. In
+// strict mode the name must be a token immediately followed by the colon
+// and the value may not contain control characters other than HTAB; lenient
+// mode trims whitespace around the name instead. ok is false for lines
+// lenient mode skips.
+func parseFieldLine(line string, lenient bool) (name, value string, ok bool, err error) {
+	name, value, found := strings.Cut(line, ":")
+	if !found {
+		if lenient {
+			return "", "", false, nil
+		}
+		return "", "", false, newStatusError(http.StatusBadRequest, "malformed header line %q", line)
+	}
+
+	value = strings.Trim(value, " \t")
+	if lenient {
+		return strings.TrimSpace(name), value, true, nil
+	}
+
+	if !isToken(name) {
+		// This includes whitespace between the name and the colon, which
+		// RFC 9112 section 5.1 requires rejecting.
+		return "", "", false, newStatusError(http.StatusBadRequest, "invalid header name %q", name)
+	}
+	if strings.IndexFunc(value, func(c rune) bool { return (c < ' ' && c != '\t') || c == 0x7f }) >= 0 {
+		return "", "", false, newStatusError(http.StatusBadRequest, "invalid value for header %q", name)
+	}
+	return name, value, true, nil
+}
+
+// checkSingleFields rejects requests repeating fields that must appear at
+// most once in strict mode.
+func checkSingleFields(h *Headers) error {
+	for _, k := range []string{HeaderHost, HeaderContentLength} {
+		if len(h.Values(k)) > 1 {
+			return newStatusError(http.StatusBadRequest, "duplicate %s header", k)
+		}
+	}
+	return nil
+}
+
+// isToken reports whether s is a non-empty token (RFC 9110 section 5.6.2).
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isTchar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isTchar(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || isDigit(c) ||
+		strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestReadRequest_Validation(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		wantStrict  int // 0 means the request is accepted
+		wantLenient int
+	}{
+		{name: "Valid request", input: "GET / HTTP/1.1\r\nHost: a\r\n\r\n"},
+		{name: "HTTP/1.0", input: "GET / HTTP/1.0\r\n\r\n"},
+		{name: "Extra request line part", input: "GET / HTTP/1.1 extra\r\n\r\n", wantStrict: 400},
+		{name: "Double space in request line", input: "GET  / HTTP/1.1\r\n\r\n", wantStrict: 400},
+		{name: "Invalid method", input: "G(T / HTTP/1.1\r\n\r\n", wantStrict: 400},
+		{name: "Lowercase method is a token", input: "get / HTTP/1.1\r\n\r\n"},
+		{name: "Unsupported version", input: "GET / HTTP/2.0\r\n\r\n", wantStrict: 505},
+		{name: "Malformed version", input: "GET / HTTP/1\r\n\r\n", wantStrict: 400},
+		{name: "Lowercase version", input: "GET / http/1.1\r\n\r\n", wantStrict: 400},
+		{name: "Control character in target", input: "GET /a\x01b HTTP/1.1\r\n\r\n", wantStrict: 400},
+		{name: "Asterisk form for OPTIONS", input: "OPTIONS * HTTP/1.1\r\n\r\n"},
+		{name: "Asterisk form for GET", input: "GET * HTTP/1.1\r\n\r\n", wantStrict: 400},
+		{name: "Absolute form", input: "GET http://example.com/echo/a HTTP/1.1\r\n\r\n"},
+		{name: "Authority form", input: "CONNECT example.com:443 HTTP/1.1\r\n\r\n", wantStrict: 400, wantLenient: 400},
+		{name: "Header without colon", input: "GET / HTTP/1.1\r\nInvalidHeader\r\n\r\n", wantStrict: 400},
+		{name: "Whitespace before colon", input: "GET / HTTP/1.1\r\nHost : a\r\n\r\n", wantStrict: 400},
+		{name: "Empty header name", input: "GET / HTTP/1.1\r\n: a\r\n\r\n", wantStrict: 400},
+		{name: "Invalid header name", input: "GET / HTTP/1.1\r\nX(Y): a\r\n\r\n", wantStrict: 400},
+		{name: "Control character in value", input: "GET / HTTP/1.1\r\nX-A: a\x00b\r\n\r\n", wantStrict: 400},
+		{name: "Tab in value", input: "GET / HTTP/1.1\r\nX-A: a\tb\r\n\r\n"},
+		{name: "Obs-fold", input: "GET / HTTP/1.1\r\nX-A: a\r\n b\r\n\r\n", wantStrict: 400},
+		{name: "Bare LF", input: "GET / HTTP/1.1\nHost: a\n\n", wantStrict: 400},
+		{name: "Duplicate Host", input: "GET / HTTP/1.1\r\nHost: a\r\nHost: b\r\n\r\n", wantStrict: 400},
+		{name: "Identical Content-Length", input: "POST / HTTP/1.1\r\nContent-Length: 1\r\nContent-Length: 1\r\n\r\nx", wantStrict: 400},
+		{name: "Bare LF in chunked body", input: "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n1\nx\n0\n\n", wantStrict: 400},
+	}
+
+	for _, tt := range tests {
+		for _, lenient := range []bool{false, true} {
+			want, mode := tt.wantStrict, "strict"
+			if lenient {
+				want, mode = tt.wantLenient, "lenient"
+			}
+			t.Run(tt.name+"/"+mode, func(t *testing.T) {
+				limits := testLimits()
+				limits.lenient = lenient
+				_, err := readRequest(bufio.NewReader(strings.NewReader(tt.input)), limits)
+
+				if want == 0 {
+					if err != nil {
+						t.Errorf("readRequest() error = %v, want nil", err)
+					}
+					return
+				}
+				var se *statusError
+				if !errors.As(err, &se) {
+					t.Fatalf("readRequest() error = %v, want status %d", err, want)
+				}
+				if se.code != want {
+					t.Errorf("readRequest() status = %d (%s), want %d", se.code, se.reason, want)
+				}
+				if se.reason == "" {
+					t.Error("readRequest() status error has no reason")
+				}
+			})
+		}
+	}
+}
+
+func TestReadRequest_LenientRecovery(t *testing.T) {
+	raw := "GET  /echo/a  HTTP/1.1  \n" +
+		"Host : example.com\n" +
+		"X-Folded: first\n" +
+		"\tsecond\n" +
+		"Garbage\n" +
+		"\n"
+
+	limits := testLimits()
+	limits.lenient = true
+
+	req, err := readRequest(bufio.NewReader(strings.NewReader(raw)), limits)
+	if err != nil {
+		t.Fatalf("readRequest() error = %v", err)
+	}
+	if req.Method != "GET" || req.Path != "/echo/a" || req.Version != "HTTP/1.1" {
+		t.Errorf("readRequest() request line = %q %q %q", req.Method, req.Path, req.Version)
+	}
+	if v, _ := req.Headers.Get("Host"); v != "example.com" {
+		t.Errorf("Host = %q, want example.com", v)
+	}
+	if v, _ := req.Headers.Get("X-Folded"); v != "first second" {
+		t.Errorf("X-Folded = %q, want unfolded value", v)
+	}
+	if req.Headers.Len() != 2 {
+		t.Errorf("Headers.Len() = %d, want 2 (malformed line skipped)", req.Headers.Len())
+	}
+}
+
+func TestIsToken(t *testing.T) {
+	for _, s := range []string{"GET", "X-Custom_Header", "a.b", "!#$%&'*+-.^_`|~"} {
+		if !isToken(s) {
+			t.Errorf("isToken(%q) = false, want true", s)
+		}
+	}
+	for _, s := range []string{"", "a b", "a:b", "a\tb", "a(b)", "é"} {
+		if isToken(s) {
+			t.Errorf("isToken(%q) = true, want false", s)
+		}
+	}
+}
@@ -23,6 +23,13 @@ echo ""
     echo -e "GET /echo/world HTTP/1.1\r\nHost: localhost:4221\r\nConnection: close\r\n\r\n"
 } | nc localhost 4221
 
+echo ""
+echo "=== Testing pipelined requests sent without waiting ==="
+echo ""
+
+# All three requests arrive together; the responses come back in order
+printf "GET / HTTP/1.1\r\nHost: localhost:4221\r\n\r\nGET /echo/hello HTTP/1.1\r\nHost: localhost:4221\r\n\r\nGET /echo/world HTTP/1.1\r\nHost: localhost:4221\r\nConnection: close\r\n\r\n" | nc localhost 4221
+
 echo ""
 echo "=== Server logs should show 'Keeping connection alive' messages ==="
 echo ""